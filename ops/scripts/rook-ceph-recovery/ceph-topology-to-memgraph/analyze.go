@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// analysis is a report over an imported topology. Queries take $limit and
+// $run, the run whose relationships they follow, and return one row per
+// finding; offline computes the same rows, in the same
+// order and with the same columns, over a topology held in memory.
+type analysis struct {
+	name        string
+	description string
+	query       string
+	columns     []string
+	offline     func(t *topology) [][]interface{}
+	// multiOSD is set on reports comparing the copies on different OSDs,
+	// which say nothing about a run of a single OSD
+	multiOSD bool
+}
+
+// defaultReportLimit is the default -limit.
+const defaultReportLimit = 1000
+
+// analyses are the reports analyze runs. Shards of an erasure-coded PG,
+// "<pool>.<pg>s<shard>", sit on one OSD each and hold different data, so the
+// reports counting copies leave them out, as ceph.IsShardPGID does offline
+// (hex PG numbers hold no 's').
+var analyses = []analysis{
+	{
+		name:        "single-copy",
+		description: "Objects present on only one OSD (needs two or more OSDs; replicated pools; EC shard PGs are left out)",
+		query: `
+			MATCH (p:PG)-[:CONTAINS {run: $run}]->(b:Object)-[:IS {run: $run}]->(:UniqueObject)<-[:CONTAINS {run: $run}]-(o:OSD)
+			WHERE NOT p.id CONTAINS 's'
+			WITH p, b, collect(DISTINCT o) AS osds
+			WHERE size(osds) = 1
+			WITH p, b, osds[0] AS o
+			OPTIONAL MATCH (:Run {id: $run})-[i:IMPORTED]->(o)
+			RETURN p.id AS pg, b.id AS object, o.id AS osd, i.host AS host
+			ORDER BY pg, object
+			LIMIT $limit
+		`,
+		columns:  []string{"pg", "object", "osd", "host"},
+		offline:  (*topology).singleCopy,
+		multiOSD: true,
+	},
+	{
+		name:        "pg-object-sets",
+		description: "OSDs holding a PG without all of the objects other OSDs have for it",
+		query: `
+			MATCH (o:OSD)-[:CONTAINS {run: $run}]->(p:PG)-[:CONTAINS {run: $run}]->(b:Object)
+			WITH o, p, count(DISTINCT b) AS total
+			OPTIONAL MATCH (p)-[:CONTAINS {run: $run}]->(h:Object)-[:IS {run: $run}]->(:UniqueObject)<-[:CONTAINS {run: $run}]-(o)
+			WITH o, p, total, count(DISTINCT h) AS held
+			WHERE held < total
+			RETURN p.id AS pg, o.id AS osd, held, total, total - held AS missing
+			ORDER BY pg, osd
+			LIMIT $limit
+		`,
+		columns: []string{"pg", "osd", "held", "total", "missing"},
+		offline: (*topology).pgObjectSets,
+	},
+	{
+		name:        "copy-mismatch",
+		description: "Objects whose copies differ in version or size (needs -dump-objects on import)",
+		query: `
+			MATCH (p:PG)-[:CONTAINS {run: $run}]->(b:Object)-[:IS {run: $run}]->(:UniqueObject)<-[c:CONTAINS {run: $run}]-(o:OSD)
+			WHERE c.version IS NOT NULL
+			WITH p, b, collect(DISTINCT c.version) AS versions, collect(DISTINCT c.size) AS sizes,
+				collect(o.id + '=' + c.version + '/' + toString(c.size)) AS copies
+			WHERE size(versions) > 1 OR size(sizes) > 1
+			RETURN p.id AS pg, b.id AS object, copies
+			ORDER BY pg, object
+			LIMIT $limit
+		`,
+		columns: []string{"pg", "object", "copies"},
+		offline: (*topology).copyMismatch,
+	},
+	{
+		name:        "no-surviving-replica",
+		description: "PGs where no OSD holds a complete copy, so every copy misses objects another has (needs two or more OSDs; replicated pools; EC shard PGs are left out)",
+		query: `
+			MATCH (p:PG)-[:CONTAINS {run: $run}]->(b:Object)
+			WHERE NOT p.id CONTAINS 's'
+			WITH p, count(DISTINCT b) AS total
+			MATCH (o:OSD)-[:CONTAINS {run: $run}]->(p)
+			OPTIONAL MATCH (p)-[:CONTAINS {run: $run}]->(h:Object)-[:IS {run: $run}]->(:UniqueObject)<-[:CONTAINS {run: $run}]-(o)
+			WITH p, total, o, count(DISTINCT h) AS held
+			WITH p, total, collect(o.id + '=' + toString(held)) AS copies, max(held) AS best
+			WHERE best < total
+			RETURN p.id AS pg, total, best, copies
+			ORDER BY pg
+			LIMIT $limit
+		`,
+		columns:  []string{"pg", "total", "best", "copies"},
+		offline:  (*topology).noSurvivingReplica,
+		multiOSD: true,
+	},
+}
+
+var analysisFormats = []string{"table", "json", "csv"}
+
+type analyzeConfig struct {
+	memgraphConfig
+
+	reports []analysis
+	output  string
+	limit   int
+	run     string
+
+	// paths are saved files to analyse offline instead of querying
+	// Memgraph, with osd the OSD of listings not named after theirs
+	paths []string
+	osd   string
+}
+
+func parseAnalyzeConfig(args []string, output io.Writer) (*analyzeConfig, error) {
+	cfg := &analyzeConfig{}
+	fs := flag.NewFlagSet("ceph-topology-to-memgraph analyze", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(output, "Usage: go run ./ceph-topology-to-memgraph analyze [flags] [file or directory...]")
+		_, _ = fmt.Fprintln(output, "Runs divergence reports over an imported topology, or offline over saved")
+		_, _ = fmt.Fprintln(output, "listings given as for import-file. Reports:")
+		for _, a := range analyses {
+			_, _ = fmt.Fprintf(output, "  %-22s %s\n", a.name, a.description)
+		}
+		_, _ = fmt.Fprintf(output, "%s compare copies across OSDs: over a single OSD every object\n", strings.Join(multiOSDReports(analyses), " and "))
+		_, _ = fmt.Fprintln(output, "has one copy and every copy is complete, so import at least two.")
+		fs.PrintDefaults()
+	}
+
+	report := fs.String("report", "all", "Comma-separated reports to run, or all")
+	fs.StringVar(&cfg.output, "output", "table", "Output format: "+strings.Join(analysisFormats, ", "))
+	fs.IntVar(&cfg.limit, "limit", defaultReportLimit, "Maximum rows per report")
+	fs.StringVar(&cfg.run, "run", "", "Run to report on (default: the latest run imported)")
+	fs.StringVar(&cfg.osd, "osd", "", "OSD ID of listing files not named osd-<id>-pgs.json, when analysing offline")
+	cfg.memgraphConfig.addFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.paths = fs.Args()
+	if cfg.osd != "" {
+		if _, err := strconv.Atoi(cfg.osd); err != nil {
+			return nil, fmt.Errorf("invalid -osd %q", cfg.osd)
+		}
+	}
+
+	if *report == "all" {
+		cfg.reports = analyses
+	} else {
+		for _, name := range strings.Split(*report, ",") {
+			a, ok := findAnalysis(strings.TrimSpace(name))
+			if !ok {
+				return nil, fmt.Errorf("unknown report %q", name)
+			}
+			cfg.reports = append(cfg.reports, a)
+		}
+	}
+
+	switch cfg.output {
+	case "table", "json":
+	case "csv":
+		if len(cfg.reports) != 1 {
+			return nil, fmt.Errorf("-output=csv needs a single -report")
+		}
+	default:
+		return nil, fmt.Errorf("unknown output format %q (want one of %s)", cfg.output, strings.Join(analysisFormats, ", "))
+	}
+	if cfg.limit < 1 {
+		return nil, fmt.Errorf("-limit must be at least 1")
+	}
+
+	if len(cfg.paths) > 0 {
+		if cfg.run != "" {
+			return nil, fmt.Errorf("-run only applies to a topology imported into Memgraph")
+		}
+		return cfg, nil
+	}
+	if err := cfg.memgraphConfig.resolve(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// multiOSDReports returns the names of the reports in analyses that need
+// more than one OSD.
+func multiOSDReports(analyses []analysis) []string {
+	var names []string
+	for _, a := range analyses {
+		if a.multiOSD {
+			names = append(names, a.name)
+		}
+	}
+	return names
+}
+
+// noteFewOSDs notes that the reports comparing OSDs mean nothing when fewer
+// than two were imported, where a report of none would otherwise look like
+// good news.
+func noteFewOSDs(w io.Writer, analyses []analysis, osds int) {
+	names := multiOSDReports(analyses)
+	if osds >= 2 || len(names) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "Note: only %d OSD imported; %s need at least two and say nothing here\n", osds, strings.Join(names, " and "))
+}
+
+func findAnalysis(name string) (analysis, bool) {
+	for _, a := range analyses {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return analysis{}, false
+}
+
+// Report is the result of one analysis.
+type Report struct {
+	Name        string                   `json:"name"`
+	Description string                   `json:"description"`
+	Columns     []string                 `json:"columns"`
+	Rows        []map[string]interface{} `json:"rows"`
+	Truncated   bool                     `json:"truncated"`
+
+	values [][]interface{}
+}
+
+// newReport returns the report of a, cutting rows to limit.
+func newReport(a analysis, columns []string, rows [][]interface{}, limit int) Report {
+	r := Report{Name: a.name, Description: a.description, Columns: columns, Rows: []map[string]interface{}{}}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		r.Truncated = true
+	}
+	for _, row := range rows {
+		m := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			m[column] = row[i]
+		}
+		r.Rows = append(r.Rows, m)
+	}
+	r.values = rows
+	return r
+}
+
+func runAnalyze(args []string) int {
+	cfg, err := parseAnalyzeConfig(args, os.Stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	ctx := context.Background()
+	if len(cfg.paths) > 0 {
+		t, err := loadTopology(ctx, cfg.paths, cfg.osd)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		noteFewOSDs(os.Stderr, cfg.reports, len(t.osds))
+		if err := writeReports(os.Stdout, cfg.output, t.reports(cfg.reports, cfg.limit)); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error writing reports: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	client, err := cfg.memgraphConfig.connect(ctx, logger)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error connecting to Memgraph: %v\n", err)
+		return 1
+	}
+	defer client.Close(ctx)
+
+	run := cfg.run
+	if run == "" {
+		if run, err = client.latestRun(ctx); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Reporting on run %s\n", run)
+	osds, err := client.runOSDs(ctx, run)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	noteFewOSDs(os.Stderr, cfg.reports, osds)
+
+	reports, err := client.runReports(ctx, cfg.reports, map[string]interface{}{"run": run}, cfg.limit)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if err := writeReports(os.Stdout, cfg.output, reports); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error writing reports: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func writeReports(w io.Writer, format string, reports []Report) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		for _, r := range reports {
+			if err := cw.Write(r.Columns); err != nil {
+				return err
+			}
+			for _, row := range r.values {
+				if err := cw.Write(formatRow(row)); err != nil {
+					return err
+				}
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	for _, r := range reports {
+		_, _ = fmt.Fprintf(w, "=== %s: %s (%d) ===\n", r.Name, r.Description, len(r.values))
+		if len(r.values) == 0 {
+			_, _ = fmt.Fprint(w, "none\n\n")
+			continue
+		}
+
+		tw := tabwriter.NewWriter(w, 1, 1, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, strings.Join(r.Columns, "\t"))
+		for _, row := range r.values {
+			_, _ = fmt.Fprintln(tw, strings.Join(formatRow(row), "\t"))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if r.Truncated {
+			_, _ = fmt.Fprintln(w, "... truncated, raise -limit to see more")
+		}
+		_, _ = fmt.Fprintln(w)
+	}
+	return nil
+}
+
+func formatRow(row []interface{}) []string {
+	cells := make([]string, len(row))
+	for i, v := range row {
+		switch v := v.(type) {
+		case nil:
+			cells[i] = ""
+		case []interface{}:
+			parts := make([]string, len(v))
+			for j, p := range v {
+				parts[j] = fmt.Sprint(p)
+			}
+			cells[i] = strings.Join(parts, " ")
+		default:
+			cells[i] = fmt.Sprint(v)
+		}
+	}
+	return cells
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNoteFewOSDs(t *testing.T) {
+	pgObjectSets, _ := findAnalysis("pg-object-sets")
+	tests := []struct {
+		name     string
+		analyses []analysis
+		osds     int
+		want     string
+	}{
+		{
+			name:     "one OSD",
+			analyses: analyses,
+			osds:     1,
+			want:     "Note: only 1 OSD imported; single-copy and no-surviving-replica need at least two and say nothing here\n",
+		},
+		{name: "two OSDs", analyses: analyses, osds: 2},
+		{name: "no report needing two", analyses: []analysis{pgObjectSets}, osds: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			noteFewOSDs(&b, tt.analyses, tt.osds)
+			if got := b.String(); got != tt.want {
+				t.Errorf("noteFewOSDs() wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+
+	"main/internal/ceph"
+	"main/internal/executor"
+)
+
+// MemgraphClient wraps the driver and session for reuse
+type MemgraphClient struct {
+	driver   neo4j.DriverWithContext
+	session  neo4j.SessionWithContext
+	database string
+	neo4j    bool    // a Neo4j server, without Memgraph's procedures
+	run      runInfo // the run whose writes the client applies
+	logger   *log.Logger
+
+	uri           string
+	reconnectTime time.Duration // how long to keep reconnecting after losing the server
+	sharedDriver  bool          // a writer's client, whose driver is closed by its parent
+}
+
+// NewMemgraphClient connects to uri. configurers adjust the driver's config
+// after the defaults below are set.
+func NewMemgraphClient(uri, username, password, database string, logger *log.Logger, configurers ...func(*neo4j.Config)) (*MemgraphClient, error) {
+	logger.Printf("Connecting to Memgraph at %s", uri)
+
+	auth := neo4j.NoAuth()
+	if username != "" {
+		auth = neo4j.BasicAuth(username, password, "")
+	}
+
+	configurers = append([]func(*neo4j.Config){func(config *neo4j.Config) {
+		config.MaxConnectionLifetime = 30 * time.Minute
+		config.MaxConnectionPoolSize = 50
+		config.ConnectionAcquisitionTimeout = 2 * time.Minute
+		// Enable keep-alive for long-lived connections
+		config.SocketKeepalive = true
+	}}, configurers...)
+	driver, err := neo4j.NewDriverWithContext(uri, auth, configurers...)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create driver: %v", err)
+	}
+
+	client := &MemgraphClient{
+		driver:   driver,
+		database: database,
+		logger:   logger,
+	}
+	// Create a long-lived session with write access
+	client.session = client.newSession(context.Background())
+
+	return client, nil
+}
+
+func (mc *MemgraphClient) newSession(ctx context.Context) neo4j.SessionWithContext {
+	return mc.driver.NewSession(ctx, neo4j.SessionConfig{
+		AccessMode:   neo4j.AccessModeWrite,
+		DatabaseName: mc.database,
+	})
+}
+
+// newWriter returns a client with a session of its own over mc's driver, for
+// one of several concurrent writers. Closing it closes only that session.
+func (mc *MemgraphClient) newWriter(ctx context.Context) graphWriter {
+	return &MemgraphClient{
+		driver:        mc.driver,
+		session:       mc.newSession(ctx),
+		database:      mc.database,
+		neo4j:         mc.neo4j,
+		run:           mc.run,
+		logger:        mc.logger,
+		uri:           mc.uri,
+		reconnectTime: mc.reconnectTime,
+		sharedDriver:  true,
+	}
+}
+
+func (mc *MemgraphClient) Close(ctx context.Context) error {
+	if mc.session != nil {
+		mc.session.Close(ctx)
+	}
+	if mc.driver != nil && !mc.sharedDriver {
+		return mc.driver.Close(ctx)
+	}
+	return nil
+}
+
+// write runs st in a write transaction. The driver retries the transaction
+// on transient errors, which include Memgraph's conflicts between concurrent
+// MERGEs, so errors from the query must reach it unwrapped. Should it give
+// up on a dropped connection, the client reconnects and runs st again.
+func (mc *MemgraphClient) write(ctx context.Context, st statement) ([]*neo4j.Record, neo4j.ResultSummary, error) {
+	var records []*neo4j.Record
+	var summary neo4j.ResultSummary
+	err := mc.reconnecting(ctx, func() error {
+		var err error
+		records, err = neo4j.ExecuteWrite(ctx, mc.session, func(tx neo4j.ManagedTransaction) ([]*neo4j.Record, error) {
+			result, err := tx.Run(ctx, st.query, st.params)
+			if err != nil {
+				return nil, err
+			}
+			records, err := result.Collect(ctx)
+			if err != nil {
+				return nil, err
+			}
+			summary, err = result.Consume(ctx)
+			return records, err
+		})
+		return err
+	})
+	return records, summary, err
+}
+
+func (mc *MemgraphClient) TestConnection(ctx context.Context) error {
+	mc.logger.Println("Testing Memgraph connection...")
+	fmt.Println("Testing Memgraph connection...")
+
+	// Test basic connectivity
+	_, rows, err := mc.Query(ctx, "RETURN 'Connection successful' as message", nil)
+	if err != nil {
+		return fmt.Errorf("failed to test connection: %v", err)
+	}
+
+	if len(rows) > 0 {
+		message := rows[0][0]
+		mc.logger.Printf("Connection test result: %v", message)
+		fmt.Printf("Connection test result: %v\n", message)
+	}
+
+	mc.logger.Println("Memgraph connection OK")
+	fmt.Println("Memgraph connection OK")
+	return nil
+}
+
+// CreateOSDNode merges the OSD node and ties it to the run, with props on the
+// run's IMPORTED relationship to it.
+func (mc *MemgraphClient) CreateOSDNode(ctx context.Context, osdID string, props map[string]interface{}) error {
+	mc.logger.Printf("Creating OSD node for ID %s", osdID)
+	fmt.Printf("Creating OSD node for ID %s\n", osdID)
+
+	records, _, err := mc.write(ctx, osdStatement(mc.run.id, osdID, props))
+	if err != nil {
+		return fmt.Errorf("failed to create OSD node: %v", err)
+	}
+
+	for _, record := range records {
+		id, _ := record.Get("id")
+		name, _ := record.Get("name")
+		mc.logger.Printf("Created OSD node: id=%v, name=%v", id, name)
+		fmt.Printf("Created OSD node: id=%v, name=%v\n", id, name)
+	}
+
+	fmt.Println("OSD node created successfully")
+	return nil
+}
+
+// MergePG merges a PG node and the OSD's CONTAINS relationship to it, with
+// the cluster's view of the PG on the run's IMPORTED relationship to it.
+func (mc *MemgraphClient) MergePG(ctx context.Context, pgID, osdID string, props map[string]interface{}) error {
+	records, _, err := mc.write(ctx, pgStatement(mc.run.id, pgID, osdID, props))
+	if err != nil {
+		return fmt.Errorf("failed to create PG node: %v", err)
+	}
+
+	for _, record := range records {
+		pgID, _ := record.Get("pg_id")
+		pgName, _ := record.Get("pg_name")
+		mc.logger.Printf("Created PG node: id=%v, name=%v", pgID, pgName)
+	}
+	return nil
+}
+
+// SetCopyProperties records an OSD's own view of a PG on its CONTAINS
+// relationship, once the listing is done and --op info can run.
+func (mc *MemgraphClient) SetCopyProperties(ctx context.Context, pgID, osdID string, props map[string]interface{}) error {
+	if _, _, err := mc.write(ctx, copyStatement(mc.run.id, pgID, osdID, props)); err != nil {
+		return fmt.Errorf("failed to set PG %s copy properties: %v", pgID, err)
+	}
+	return nil
+}
+
+// SetObjectDetails records what was dumped from an OSD's copies of objects on
+// the run's CONTAINS relationships to them.
+func (mc *MemgraphClient) SetObjectDetails(ctx context.Context, objects []listedObject, osdID string) error {
+	if _, _, err := mc.write(ctx, objectDetailsStatement(mc.run.id, objects, osdID)); err != nil {
+		return fmt.Errorf("failed to set object details: %v", err)
+	}
+	return nil
+}
+
+// WriteObjects merges a batch of an OSD's objects in one of its PGs, in a
+// single write transaction.
+func (mc *MemgraphClient) WriteObjects(ctx context.Context, objects []listedObject, pgID, osdID string) error {
+	if len(objects) == 0 {
+		return nil
+	}
+
+	_, summary, err := mc.write(ctx, objectsStatement(mc.run.id, objects, pgID, osdID))
+	if err != nil {
+		return fmt.Errorf("failed to create batch objects: %v", err)
+	}
+
+	mc.logger.Printf("Batch processed: %d objects, nodes created: %d, relationships created: %d",
+		len(objects), summary.Counters().NodesCreated(), summary.Counters().RelationshipsCreated())
+
+	fmt.Printf("Created %d objects in PG %s\n", len(objects), pgID)
+	mc.logger.Printf("Created %d objects in PG %s", len(objects), pgID)
+
+	return nil
+}
+
+func (mc *MemgraphClient) CreateSnapshot(ctx context.Context) error {
+	mc.logger.Println("Creating snapshot...")
+	fmt.Println("Creating snapshot...")
+
+	if _, _, err := mc.Query(ctx, "CALL mg.create_snapshot()", nil); err != nil {
+		return fmt.Errorf("failed to create snapshot: %v", err)
+	}
+
+	mc.logger.Println("Snapshot created successfully")
+	fmt.Println("Snapshot created successfully")
+	return nil
+}
+
+// Query runs a query in an auto-commit transaction and returns its columns
+// and rows, reconnecting if the connection drops.
+func (mc *MemgraphClient) Query(ctx context.Context, query string, params map[string]interface{}) ([]string, [][]interface{}, error) {
+	var keys []string
+	var rows [][]interface{}
+	err := mc.reconnecting(ctx, func() error {
+		result, err := mc.session.Run(ctx, query, params)
+		if err != nil {
+			return err
+		}
+
+		keys, err = result.Keys()
+		if err != nil {
+			return err
+		}
+		records, err := result.Collect(ctx)
+		if err != nil {
+			return err
+		}
+
+		rows = make([][]interface{}, 0, len(records))
+		for _, record := range records {
+			rows = append(rows, record.Values)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, rows, nil
+}
+
+// GetStats prints database statistics for monitoring
+func (mc *MemgraphClient) GetStats(ctx context.Context) error {
+	queries := []struct {
+		name  string
+		query string
+	}{
+		{"Run Count", "MATCH (r:Run) RETURN count(r) as count"},
+		{"OSD Count", "MATCH (o:OSD) RETURN count(o) as count"},
+		{"PG Count", "MATCH (p:PG) RETURN count(p) as count"},
+		{"Object Count", "MATCH (obj:Object) RETURN count(obj) as count"},
+		{"UniqueObject Count", "MATCH (uo:UniqueObject) RETURN count(uo) as count"},
+		{"Relationship Count", "MATCH ()-[r]->() RETURN count(r) as count"},
+	}
+
+	fmt.Println("\n=== Database Statistics ===")
+	mc.logger.Println("Database Statistics:")
+
+	for _, q := range queries {
+		_, rows, err := mc.Query(ctx, q.query, nil)
+		if err != nil {
+			mc.logger.Printf("Error getting %s: %v", q.name, err)
+			continue
+		}
+
+		if len(rows) > 0 {
+			count := rows[0][0]
+			fmt.Printf("%s: %v\n", q.name, count)
+			mc.logger.Printf("%s: %v", q.name, count)
+		}
+	}
+
+	fmt.Print("=============================\n\n")
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "analyze":
+			os.Exit(runAnalyze(os.Args[2:]))
+		case "import-file":
+			os.Exit(runImportFile(os.Args[2:]))
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "runs":
+			os.Exit(runRuns(os.Args[2:]))
+		}
+	}
+
+	cfg, err := parseConfig(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	// Check for required commands
+	requiredCmds := []string{"kubectl"}
+	for _, cmd := range requiredCmds {
+		if !commandExists(cmd) {
+			log.Fatalf("Error: %s is required but not installed", cmd)
+		}
+	}
+
+	ctx := context.Background()
+	ex := executor.KubeContext{Executor: executor.Command{}, Context: cfg.kubeContext}
+
+	targets, err := resolveTargets(ctx, cfg, ex)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	os.Exit(runImport(ctx, cfg, ex, targets, nil))
+}
+
+// runImport lists the targets through ex and writes them to Memgraph. The
+// live import and import-file differ only in the executor and targets; the
+// latter may also bring PG queries saved by reconcile-dodgy-pgs.
+func runImport(ctx context.Context, cfg *config, ex executor.Executor, targets []osdTarget, queries []ceph.PGQuery) int {
+	// Generate unique hash for deduplication, or pick up the run to resume
+	dedupeHash := cfg.resume
+	if dedupeHash == "" {
+		var err error
+		dedupeHash, err = generateRandomHex(8)
+		if err != nil {
+			log.Fatalf("Error generating random hash: %v", err)
+		}
+	}
+
+	logFile := filepath.Join(cfg.logDir, fmt.Sprintf("memgraph_insert_%s.log", dedupeHash))
+	tempCypherDir := filepath.Join(cfg.logDir, fmt.Sprintf("cypher_%s", dedupeHash))
+
+	// Create log file and temp directory
+	if cfg.resume != "" {
+		if _, err := os.Stat(tempCypherDir); err != nil {
+			log.Fatalf("Error: nothing to resume for run %s: %v", cfg.resume, err)
+		}
+		fmt.Printf("Resuming run %s\n", dedupeHash)
+	} else {
+		if err := createLogFile(logFile); err != nil {
+			log.Fatalf("Error creating log file: %v", err)
+		}
+		fmt.Printf("To resume this run, pass -resume %s\n", dedupeHash)
+	}
+
+	if err := os.MkdirAll(tempCypherDir, 0755); err != nil {
+		log.Fatalf("Error creating temp directory: %v", err)
+	}
+
+	logf, err := os.OpenFile(logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Fatalf("Error opening log file: %v", err)
+	}
+	defer logf.Close()
+
+	logger := log.New(logf, "", log.LstdFlags)
+
+	var meta *clusterMetadata
+	if cfg.placement {
+		meta = loadClusterMetadata(ctx, ex, tempCypherDir, logger)
+	}
+	if len(queries) > 0 {
+		if meta == nil {
+			meta = newClusterMetadata()
+		}
+		meta.addQueries(queries)
+	}
+
+	// The run's id is its hash, so a resumed run merges into the same Run
+	run := runInfo{id: dedupeHash, note: cfg.note}
+	if meta != nil {
+		run.fsid = meta.fsid
+	}
+
+	var sink graphSink
+	var cp *checkpoint
+	if !cfg.dryRun {
+		cp, err = loadCheckpoint(filepath.Join(tempCypherDir, "checkpoint.json"), dedupeHash)
+		if err != nil {
+			log.Fatalf("Error loading checkpoint: %v", err)
+		}
+
+		sink, err = openSink(ctx, cfg, run, tempCypherDir, logger)
+		if err != nil {
+			log.Fatalf("Error opening %s sink: %v", cfg.sink, err)
+		}
+		defer sink.Close(ctx)
+
+		if err := sink.Prepare(ctx); err != nil {
+			log.Fatalf("Error preparing %s sink: %v", cfg.sink, err)
+		}
+	}
+
+	// Listings are streamed concurrently and written to the sink by
+	// cfg.writers writers, each over a session of its own on Bolt sinks
+	imports, failed := streamTargets(ctx, cfg, ex, sink, targets, meta, cp, tempCypherDir, cfg.resume != "", logger)
+
+	printSummary(imports)
+
+	if cfg.dryRun {
+		fmt.Printf("Dry run: nothing was written to %s\n", sinkOutput(cfg, tempCypherDir))
+	} else if err := sink.Finish(ctx); err != nil {
+		log.Fatalf("Error finishing %s sink: %v", cfg.sink, err)
+	}
+
+	fmt.Printf("Processing complete for %d OSDs. Logs in %s\n", len(targets), logFile)
+	fmt.Printf("PGs recorded locally: %s\n", tempCypherDir)
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func commandExists(cmd string) bool {
+	_, err := exec.LookPath(cmd)
+	return err == nil
+}
+
+func generateRandomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func createLogFile(logFile string) error {
+	f, err := os.Create(logFile)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// checkpoint records which objects of a run have been committed to Memgraph,
+// so a run started with -resume can skip them. It lives next to the saved
+// listings in the run's directory and is rewritten after every batch.
+//
+// Every write is a MERGE, so replaying a batch that was committed but not yet
+// checkpointed is harmless.
+type checkpoint struct {
+	path string
+	mu   sync.Mutex
+
+	RunID string `json:"run_id"`
+	// PGs maps OSD ID to PG ID to progress.
+	PGs map[string]map[string]*pgProgress `json:"pgs"`
+}
+
+type pgProgress struct {
+	// Objects is the number of the PG's objects committed, counted in the
+	// order of the OSD's saved listing. A fresh listing may order them
+	// differently, so the count only holds while that listing is reused.
+	Objects int  `json:"objects"`
+	Done    bool `json:"done"`
+}
+
+// loadCheckpoint reads the checkpoint at path, or starts an empty one if
+// there is none yet.
+func loadCheckpoint(path, runID string) (*checkpoint, error) {
+	cp := &checkpoint{path: path, RunID: runID, PGs: make(map[string]map[string]*pgProgress)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("parsing checkpoint %s: %v", path, err)
+	}
+	if cp.RunID != runID {
+		return nil, fmt.Errorf("checkpoint %s belongs to run %s, not %s", path, cp.RunID, runID)
+	}
+	if cp.PGs == nil {
+		cp.PGs = make(map[string]map[string]*pgProgress)
+	}
+	return cp, nil
+}
+
+// progress returns the progress of a PG on an OSD. A nil checkpoint, as in
+// a dry run, tracks nothing and always reports no progress.
+func (c *checkpoint) progress(osdID, pgid string) pgProgress {
+	if c == nil {
+		return pgProgress{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.entry(osdID, pgid)
+}
+
+// commit records n more objects of a PG as written.
+func (c *checkpoint) commit(osdID, pgid string, n int) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entry(osdID, pgid).Objects += n
+	return c.save()
+}
+
+// restart forgets the objects of a PG committed so far, as they are about to
+// be written again.
+func (c *checkpoint) restart(osdID, pgid string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entry(osdID, pgid).Objects = 0
+	return c.save()
+}
+
+// done records that every object of a PG has been written.
+func (c *checkpoint) done(osdID, pgid string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entry(osdID, pgid).Done = true
+	return c.save()
+}
+
+func (c *checkpoint) entry(osdID, pgid string) *pgProgress {
+	if c.PGs[osdID] == nil {
+		c.PGs[osdID] = make(map[string]*pgProgress)
+	}
+	if c.PGs[osdID][pgid] == nil {
+		c.PGs[osdID][pgid] = &pgProgress{}
+	}
+	return c.PGs[osdID][pgid]
+}
+
+// save writes the checkpoint through a temporary file, so a crash mid-write
+// leaves the previous checkpoint intact. The caller holds mu.
+func (c *checkpoint) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"main/internal/ceph"
+	"main/internal/executor"
+)
+
+func TestLoadCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "checkpoint.json")
+
+	cp, err := loadCheckpoint(path, "abc")
+	if err != nil {
+		t.Fatalf("loadCheckpoint() without a checkpoint: %v", err)
+	}
+	if got := cp.progress("0", "1.0"); got != (pgProgress{}) {
+		t.Errorf("progress of a new checkpoint = %+v", got)
+	}
+	if err := cp.commit("0", "1.0", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.commit("0", "1.0", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.done("0", "1.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err = loadCheckpoint(path, "abc")
+	if err != nil {
+		t.Fatalf("loadCheckpoint() = %v", err)
+	}
+	if got, want := cp.progress("0", "1.0"), (pgProgress{Objects: 5}); got != want {
+		t.Errorf("progress of 1.0 = %+v, want %+v", got, want)
+	}
+	if got, want := cp.progress("0", "1.1"), (pgProgress{Done: true}); got != want {
+		t.Errorf("progress of 1.1 = %+v, want %+v", got, want)
+	}
+	if err := cp.restart("0", "1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if got := cp.progress("0", "1.0"); got != (pgProgress{}) {
+		t.Errorf("progress of 1.0 after restart = %+v", got)
+	}
+
+	if _, err := loadCheckpoint(path, "def"); err == nil || !strings.Contains(err.Error(), "belongs to run abc") {
+		t.Errorf("loadCheckpoint() of another run = %v, want a run mismatch", err)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCheckpoint(path, "abc"); err == nil {
+		t.Error("loadCheckpoint() of a truncated checkpoint succeeded")
+	}
+}
+
+func TestNilCheckpoint(t *testing.T) {
+	var cp *checkpoint
+	if err := cp.commit("0", "1.0", 1); err != nil {
+		t.Errorf("commit() = %v", err)
+	}
+	if err := cp.done("0", "1.0"); err != nil {
+		t.Errorf("done() = %v", err)
+	}
+	if got := cp.progress("0", "1.0"); got != (pgProgress{}) {
+		t.Errorf("progress() = %+v", got)
+	}
+}
+
+func TestResume(t *testing.T) {
+	listing := strings.Join([]string{
+		listingLine("1.0", "a"),
+		listingLine("1.0", "b"),
+		listingLine("1.0", "c"),
+		listingLine("1.1", "d"),
+	}, "\n") + "\n"
+	// A fresh listing of the same OSD, in another order
+	relisted := strings.Join([]string{
+		listingLine("1.0", "c"),
+		listingLine("1.0", "b"),
+		listingLine("1.0", "a"),
+		listingLine("1.1", "d"),
+	}, "\n") + "\n"
+
+	tests := []struct {
+		name  string
+		saved bool // whether the first attempt's listing was saved
+		want  []string
+	}{
+		{
+			name:  "saved listing",
+			saved: true,
+			want:  []string{"osd 0", "pg 0 1.0", "objects 0 1.0 c"},
+		},
+		{
+			name: "listed again",
+			want: []string{"osd 0", "pg 0 1.0", "objects 0 1.0 c,b", "objects 0 1.0 a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.saved {
+				if err := os.WriteFile(filepath.Join(dir, "osd-0-pgs.json"), []byte(listing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				// The first attempt died while listing
+				if err := os.WriteFile(filepath.Join(dir, "osd-0-pgs.json.partial"), []byte(listing[:10]), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(dir, "relisted.json"), []byte(relisted), 0644); err != nil {
+				t.Fatal(err)
+			}
+			fixture := executor.NewFixture(dir)
+			cmd := ceph.ListCommand("rook-ceph", "pod-0", 0, "")
+			fixture.File("relisted.json", cmd[0], cmd[1:]...)
+
+			// The first attempt committed a and b of 1.0, and all of 1.1
+			path := filepath.Join(dir, "checkpoint.json")
+			cp, err := loadCheckpoint(path, "abc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cp.commit("0", "1.0", 2); err != nil {
+				t.Fatal(err)
+			}
+			if err := cp.done("0", "1.1"); err != nil {
+				t.Fatal(err)
+			}
+
+			sink := &recordingSink{}
+			_, failed := streamTargets(context.Background(), testConfig(), fixture, sink, []osdTarget{{id: "0", pod: "pod-0"}}, nil, cp, dir, true, testLogger())
+			if failed {
+				t.Fatal("import failed")
+			}
+			if got := sink.recorded(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("writes = %q, want %q", got, tt.want)
+			}
+
+			cp, err = loadCheckpoint(path, "abc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := cp.progress("0", "1.0"), (pgProgress{Objects: 3, Done: true}); got != want {
+				t.Errorf("progress of 1.0 = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
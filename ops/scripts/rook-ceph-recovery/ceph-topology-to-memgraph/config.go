@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supportedSchemes are the Bolt URI schemes the driver accepts.
+var supportedSchemes = []string{"bolt", "bolt+s", "bolt+ssc", "neo4j", "neo4j+s", "neo4j+ssc"}
+
+// runIDRegexp matches the hashes generated for runs, which name their log
+// file and directory.
+var runIDRegexp = regexp.MustCompile(`^[0-9a-f]+$`)
+
+type config struct {
+	osdPod      string
+	osds        []string
+	allOSDs     bool
+	namespace   string
+	kubeContext string
+	concurrency int
+	placement   bool
+	pgInfo      bool
+	dumpObjects *regexp.Regexp
+
+	memgraphConfig
+	writeConfig
+
+	logDir string
+	dryRun bool
+	resume string
+}
+
+// memgraphConfig holds the connection flags shared by every subcommand.
+type memgraphConfig struct {
+	memgraphURI string
+	user        string
+	password    string
+	database    string
+
+	passwordEnv  string
+	passwordFile string
+
+	// caFile is a PEM file of CAs to trust on bolt+s and neo4j+s URIs,
+	// read into rootCAs
+	caFile        string
+	rootCAs       *x509.CertPool
+	reconnectTime time.Duration
+}
+
+func (m *memgraphConfig) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&m.memgraphURI, "uri", "bolt://localhost:7687", "Memgraph URI ("+strings.Join(supportedSchemes, ", ")+")")
+	fs.StringVar(&m.user, "user", "", "Memgraph user (default: no authentication)")
+	fs.StringVar(&m.passwordEnv, "password-env", "MEMGRAPH_PASSWORD", "Environment variable holding the Memgraph password")
+	fs.StringVar(&m.passwordFile, "password-file", "", "File holding the Memgraph password (overrides -password-env)")
+	fs.StringVar(&m.database, "database", "", "Database name (default: the server's default database)")
+	fs.StringVar(&m.caFile, "ca-file", "", "PEM file of the CA to trust on bolt+s and neo4j+s URIs, such as certs/rea-root.cert (default: the system's CAs)")
+	fs.DurationVar(&m.reconnectTime, "reconnect-time", 2*time.Minute, "How long to keep retrying when Memgraph cannot be reached or drops the connection")
+}
+
+// resolve validates the URI and reads the password, once flags are parsed.
+func (m *memgraphConfig) resolve() error {
+	if err := validateURI(m.memgraphURI); err != nil {
+		return err
+	}
+
+	if m.passwordFile != "" {
+		data, err := os.ReadFile(m.passwordFile)
+		if err != nil {
+			return fmt.Errorf("reading password file: %v", err)
+		}
+		m.password = strings.TrimRight(string(data), "\r\n")
+	} else if m.passwordEnv != "" {
+		m.password = os.Getenv(m.passwordEnv)
+	}
+	if m.password != "" && m.user == "" {
+		return fmt.Errorf("a password was given but -user is empty")
+	}
+
+	if m.caFile != "" {
+		if u, _ := url.Parse(m.memgraphURI); !strings.HasSuffix(u.Scheme, "+s") {
+			return fmt.Errorf("-ca-file needs a bolt+s or neo4j+s URI, not %s", u.Scheme)
+		}
+		data, err := os.ReadFile(m.caFile)
+		if err != nil {
+			return fmt.Errorf("reading CA file: %v", err)
+		}
+		m.rootCAs = x509.NewCertPool()
+		if !m.rootCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no PEM certificates in CA file %s", m.caFile)
+		}
+	}
+	if m.reconnectTime < 0 {
+		return fmt.Errorf("-reconnect-time must not be negative")
+	}
+	return nil
+}
+
+// writeConfig holds the flags choosing where imports write the topology and
+// tuning how.
+type writeConfig struct {
+	sink       string
+	sinkOutput string
+	batchSize  int
+	writers    int
+	retryTime  time.Duration
+	note       string
+}
+
+func (w *writeConfig) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&w.sink, "sink", "memgraph", "Where to write the topology ("+strings.Join(sinks, ", ")+"); memory prints the analyze reports instead")
+	fs.StringVar(&w.sinkOutput, "sink-output", "", "File for the cypherl and graphml sinks, or directory for the csv sink (default: in the run's directory)")
+	fs.IntVar(&w.batchSize, "batch-size", 200, "Number of objects written per transaction")
+	fs.IntVar(&w.writers, "writers", 4, "Number of concurrent writers, each with its own Memgraph session")
+	fs.DurationVar(&w.retryTime, "retry-time", time.Minute, "How long to keep retrying a write that fails with a transient error, such as a conflict between concurrent MERGEs")
+	fs.StringVar(&w.note, "note", "", "Note recorded on the run's node, such as \"before recovery\"")
+}
+
+func (w *writeConfig) validate() error {
+	known := false
+	for _, sink := range sinks {
+		if w.sink == sink {
+			known = true
+		}
+	}
+	if !known {
+		return fmt.Errorf("invalid -sink %q: must be one of %s", w.sink, strings.Join(sinks, ", "))
+	}
+	if w.batchSize < 1 {
+		return fmt.Errorf("-batch-size must be at least 1")
+	}
+	if w.writers < 1 {
+		return fmt.Errorf("-writers must be at least 1")
+	}
+	if w.retryTime < 0 {
+		return fmt.Errorf("-retry-time must not be negative")
+	}
+	return nil
+}
+
+func parseConfig(args []string, output io.Writer) (*config, error) {
+	cfg := &config{}
+	fs := flag.NewFlagSet("ceph-topology-to-memgraph", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(output, "Usage: go run ./ceph-topology-to-memgraph -pod <osd_pod_name> | -osds <id,...> | -all-osds [flags]")
+		_, _ = fmt.Fprintln(output, "       go run ./ceph-topology-to-memgraph analyze [flags] [file or directory...]")
+		_, _ = fmt.Fprintln(output, "       go run ./ceph-topology-to-memgraph import-file [flags] <file or directory>...")
+		_, _ = fmt.Fprintln(output, "       go run ./ceph-topology-to-memgraph diff [flags] <before> <after>")
+		_, _ = fmt.Fprintln(output, "       go run ./ceph-topology-to-memgraph runs list|diff|delete [flags] [run...]")
+		_, _ = fmt.Fprintln(output, "Example: go run ./ceph-topology-to-memgraph -pod rook-ceph-osd-0-maintenance-abc -uri bolt://metal-nina:7687")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&cfg.osdPod, "pod", "", "OSD (maintenance) pod to list objects from")
+	osds := fs.String("osds", "", "Comma-separated OSD IDs whose maintenance pods to list objects from")
+	fs.BoolVar(&cfg.allOSDs, "all-osds", false, "List objects from every OSD that has a maintenance pod")
+	fs.IntVar(&cfg.concurrency, "concurrency", 3, "Number of OSDs to list objects from at once")
+	fs.BoolVar(&cfg.placement, "placement", true, "Record CRUSH placement and PG state from the cluster on OSD and PG nodes")
+	fs.BoolVar(&cfg.pgInfo, "pg-info", false, "Also record each OSD's own info for every PG it holds (one ceph-objectstore-tool run per PG, slow)")
+	dumpObjects := fs.String("dump-objects", "", "Regexp of object IDs ([namespace/]oid[#key][@snapid]) to dump on every OSD, recording size, version and mtime (one ceph-objectstore-tool run per object)")
+	fs.StringVar(&cfg.namespace, "namespace", "rook-ceph", "Namespace of the OSD pod")
+	fs.StringVar(&cfg.kubeContext, "kube-context", "", "kubectl context to use (default: current context)")
+	cfg.memgraphConfig.addFlags(fs)
+	cfg.writeConfig.addFlags(fs)
+	fs.StringVar(&cfg.logDir, "log-dir", os.TempDir(), "Directory for the log file and saved object listings")
+	fs.StringVar(&cfg.resume, "resume", "", "Resume the run with this hash, reusing its saved listings and skipping committed batches")
+	fs.BoolVar(&cfg.dryRun, "dry-run", false, "List and parse objects but do not connect to or write to Memgraph")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if *osds != "" {
+		for _, id := range strings.Split(*osds, ",") {
+			id = strings.TrimSpace(id)
+			if _, err := strconv.Atoi(id); err != nil {
+				return nil, fmt.Errorf("invalid OSD ID %q in -osds", id)
+			}
+			cfg.osds = append(cfg.osds, id)
+		}
+	}
+
+	if *dumpObjects != "" {
+		re, err := regexp.Compile(*dumpObjects)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -dump-objects: %v", err)
+		}
+		cfg.dumpObjects = re
+	}
+
+	modes := 0
+	for _, set := range []bool{cfg.osdPod != "", len(cfg.osds) > 0, cfg.allOSDs} {
+		if set {
+			modes++
+		}
+	}
+	if modes != 1 {
+		return nil, fmt.Errorf("exactly one of -pod, -osds or -all-osds is required")
+	}
+	if cfg.concurrency < 1 {
+		return nil, fmt.Errorf("-concurrency must be at least 1")
+	}
+	if cfg.namespace == "" {
+		return nil, fmt.Errorf("-namespace must not be empty")
+	}
+	if err := cfg.writeConfig.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.memgraphConfig.resolve(); err != nil {
+		return nil, err
+	}
+
+	if cfg.resume != "" && !runIDRegexp.MatchString(cfg.resume) {
+		return nil, fmt.Errorf("invalid -resume %q: expected the hash printed by an earlier run", cfg.resume)
+	}
+
+	if err := os.MkdirAll(cfg.logDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating log directory: %v", err)
+	}
+
+	return cfg, nil
+}
+
+func validateURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("invalid Memgraph URI %q: %v", uri, err)
+	}
+
+	supported := false
+	for _, scheme := range supportedSchemes {
+		if u.Scheme == scheme {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("invalid Memgraph URI %q: scheme must be one of %s", uri, strings.Join(supportedSchemes, ", "))
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid Memgraph URI %q: missing host", uri)
+	}
+	return nil
+}
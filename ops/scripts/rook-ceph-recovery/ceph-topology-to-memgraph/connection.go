@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// maxBackoff caps the wait between attempts to reach the server.
+const maxBackoff = 30 * time.Second
+
+// connect creates a client for m's server, trusting m's CA on TLS URIs, and
+// waits for the server to answer. configurers adjust the driver's config.
+func (m *memgraphConfig) connect(ctx context.Context, logger *log.Logger, configurers ...func(*neo4j.Config)) (*MemgraphClient, error) {
+	if m.rootCAs != nil {
+		configurers = append(configurers, func(config *neo4j.Config) {
+			config.TlsConfig = &tls.Config{RootCAs: m.rootCAs, MinVersion: tls.VersionTLS12}
+		})
+	}
+	client, err := NewMemgraphClient(m.memgraphURI, m.user, m.password, m.database, logger, configurers...)
+	if err != nil {
+		return nil, err
+	}
+	client.uri = m.memgraphURI
+	client.reconnectTime = m.reconnectTime
+
+	if err := client.verifyConnectivity(ctx); err != nil {
+		client.Close(ctx)
+		return nil, err
+	}
+	return client, nil
+}
+
+// verifyConnectivity waits for the server to accept a connection, backing off
+// between attempts for up to mc.reconnectTime. Failures retrying cannot fix,
+// such as bad credentials or an untrusted certificate, end it at once.
+func (mc *MemgraphClient) verifyConnectivity(ctx context.Context) error {
+	deadline := time.Now().Add(mc.reconnectTime)
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := mc.driver.VerifyConnectivity(ctx)
+		if err == nil {
+			if attempt > 1 {
+				mc.logger.Printf("Reached %s after %d attempts", mc.uri, attempt)
+			}
+			return nil
+		}
+		if isPermanentConnectError(err) || time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("cannot reach %s: %v", mc.uri, err)
+		}
+		mc.logger.Printf("Cannot reach %s yet (%v); retrying in %s", mc.uri, err, delay)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+		delay = min(delay*2, maxBackoff)
+	}
+}
+
+// reconnecting runs f, which uses mc.session, and when it fails because the
+// connection dropped opens a new session and runs it again, backing off for
+// up to mc.reconnectTime. Memgraph on a tailnet node drops connections during
+// large imports. The importer's statements are MERGEs, so running one again
+// is safe even when the first attempt's commit was lost with the connection.
+func (mc *MemgraphClient) reconnecting(ctx context.Context, f func() error) error {
+	deadline := time.Now().Add(mc.reconnectTime)
+	delay := time.Second
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if !isConnectionLost(err) || time.Now().Add(delay).After(deadline) {
+			return describeServerError(err)
+		}
+		mc.logger.Printf("Lost the connection to %s (%v); reconnecting in %s", mc.uri, err, delay)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+		mc.session.Close(ctx)
+		mc.session = mc.newSession(ctx)
+		delay = min(delay*2, maxBackoff)
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// lastError returns the last error of a transaction the driver gave up
+// retrying, or err itself.
+func lastError(err error) error {
+	var limit *neo4j.TransactionExecutionLimit
+	if errors.As(err, &limit) && len(limit.Errors) > 0 {
+		return limit.Errors[len(limit.Errors)-1]
+	}
+	return err
+}
+
+// isConnectionLost reports whether err, or the last error of a transaction
+// the driver gave up retrying, is a lost or refused connection.
+func isConnectionLost(err error) bool {
+	var connErr *neo4j.ConnectivityError
+	return errors.As(lastError(err), &connErr)
+}
+
+// isPermanentConnectError reports whether connecting failed in a way that
+// retrying will not fix. The driver flattens TLS errors into strings.
+func isPermanentConnectError(err error) bool {
+	var authErr *neo4j.InvalidAuthenticationError
+	if errors.As(err, &authErr) {
+		return true
+	}
+	var dbErr *neo4j.Neo4jError
+	if errors.As(err, &dbErr) && dbErr.HasSecurityCode() {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "x509:") || strings.Contains(msg, "tls:")
+}
+
+// Server error codes of writes that cannot succeed on this server. Memgraph
+// sends one generic code for every error, so its errors are told apart by
+// their exact message.
+const (
+	codeNotALeader             = "Neo.ClientError.Cluster.NotALeader"
+	codeAccessMode             = "Neo.ClientError.Statement.AccessMode"
+	codeForbiddenOnReadOnly    = "Neo.ClientError.General.ForbiddenOnReadOnlyDatabase"
+	codeOutOfMemory            = "Neo.TransientError.General.OutOfMemoryError"
+	codeMemoryPoolOutOfMemory  = "Neo.TransientError.General.MemoryPoolOutOfMemoryError"
+	codeTransactionMemoryLimit = "Neo.TransientError.General.TransactionMemoryLimit"
+	codeMemgraph               = "Memgraph.ClientError.MemgraphError.MemgraphError"
+
+	memgraphReplicaWrite = "Write query forbidden on the replica!"
+	memgraphMemoryLimit  = "memory limit exceeded"
+)
+
+// describeServerError explains the errors of a server that is reachable but
+// cannot take the writes: a read-only one, such as a Memgraph replica, or one
+// out of memory. Other errors are returned as they are.
+func describeServerError(err error) error {
+	var dbErr *neo4j.Neo4jError
+	if !errors.As(lastError(err), &dbErr) {
+		return err
+	}
+
+	const readOnly = "%w (the server is read-only: point -uri at Memgraph's MAIN instance, or at a writable Neo4j database)"
+	const outOfMemory = "%w (the server is out of memory: lower -batch-size or -writers, or raise Memgraph's --memory-limit)"
+	switch dbErr.Code {
+	case codeNotALeader, codeAccessMode, codeForbiddenOnReadOnly:
+		return fmt.Errorf(readOnly, err)
+	case codeOutOfMemory, codeMemoryPoolOutOfMemory, codeTransactionMemoryLimit:
+		return fmt.Errorf(outOfMemory, err)
+	case codeMemgraph:
+		switch {
+		case strings.Contains(dbErr.Msg, memgraphReplicaWrite):
+			return fmt.Errorf(readOnly, err)
+		case strings.Contains(strings.ToLower(dbErr.Msg), memgraphMemoryLimit):
+			return fmt.Errorf(outOfMemory, err)
+		}
+	}
+	return err
+}
+
+// checkWritable fails when Memgraph is a replica, which refuses every write,
+// before anything is listed. Servers that cannot say are assumed writable.
+func (mc *MemgraphClient) checkWritable(ctx context.Context) error {
+	_, rows, err := mc.Query(ctx, "SHOW REPLICATION ROLE", nil)
+	if err != nil {
+		mc.logger.Printf("Cannot read the replication role: %v", err)
+		return nil
+	}
+	if len(rows) > 0 && len(rows[0]) > 0 && strings.EqualFold(fmt.Sprint(rows[0][0]), "replica") {
+		return fmt.Errorf("Memgraph at %s is a replica and read-only: point -uri at the MAIN instance", mc.uri)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+func TestDescribeServerError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string // what the description adds, or "" for none
+	}{
+		{
+			name: "Neo4j follower",
+			err:  &neo4j.Neo4jError{Code: codeNotALeader, Msg: "No write operations are allowed on this database."},
+			want: "read-only",
+		},
+		{
+			name: "Neo4j read session",
+			err:  &neo4j.Neo4jError{Code: codeAccessMode, Msg: "Writing in read access mode not allowed."},
+			want: "read-only",
+		},
+		{
+			name: "Neo4j read-only database",
+			err:  &neo4j.Neo4jError{Code: codeForbiddenOnReadOnly, Msg: "This is a read only database."},
+			want: "read-only",
+		},
+		{
+			name: "Memgraph replica",
+			err:  &neo4j.Neo4jError{Code: codeMemgraph, Msg: "Write query forbidden on the replica!"},
+			want: "read-only",
+		},
+		{
+			name: "Memgraph error mentioning a replica",
+			err:  &neo4j.Neo4jError{Code: codeMemgraph, Msg: "Couldn't register replica 'r1'!"},
+		},
+		{
+			name: "Memgraph memory limit",
+			err:  &neo4j.Neo4jError{Code: codeMemgraph, Msg: "Memory limit exceeded! Attempting to allocate a chunk of 2.00MiB."},
+			want: "out of memory",
+		},
+		{
+			name: "Neo4j transaction memory limit",
+			err:  &neo4j.Neo4jError{Code: codeTransactionMemoryLimit, Msg: "The allocation of an extra 2.0 MiB would use more than the limit"},
+			want: "out of memory",
+		},
+		{
+			name: "given up after retries",
+			err: &neo4j.TransactionExecutionLimit{Errors: []error{
+				&neo4j.Neo4jError{Code: codeNotALeader, Msg: "No write operations are allowed on this database."},
+			}},
+			want: "read-only",
+		},
+		{
+			name: "syntax error",
+			err:  &neo4j.Neo4jError{Code: "Neo.ClientError.Statement.SyntaxError", Msg: "Invalid input 'replica'"},
+		},
+		{
+			name: "not a server error",
+			err:  fmt.Errorf("read-only file system"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describeServerError(tt.err)
+			if !errors.Is(got, tt.err) {
+				t.Errorf("describeServerError does not wrap the error: %v", got)
+			}
+			added := strings.TrimPrefix(got.Error(), tt.err.Error())
+			if tt.want == "" {
+				if added != "" {
+					t.Errorf("described as %q, want it unchanged", added)
+				}
+				return
+			}
+			if !strings.Contains(added, tt.want) {
+				t.Errorf("described as %q, want %q", added, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statement is a parameterised Cypher statement. The same statements are run
+// over Bolt and rendered into .cypherl files, so both hold the same graph.
+//
+// Nodes are shared by every import and hold only what never changes, such as
+// an object's ghobject fields. Relationships belong to the run that merged
+// them, through their run property or, for IMPORTED, the Run node they start
+// from, so what each run saw of the cluster stays apart. What a run saw of an
+// OSD or PG is on its IMPORTED relationship to the node.
+type statement struct {
+	query  string
+	params map[string]interface{}
+}
+
+// runStatement merges the run's node. On resume only the note and fsid given
+// again replace the recorded ones.
+func runStatement(run runInfo) statement {
+	return statement{
+		query: `
+			MERGE (r:Run {id: $run})
+			ON CREATE SET
+				r.started_at = $started_at,
+				r.name = $run_name
+			SET r += $props
+		`,
+		params: map[string]interface{}{
+			"run":        run.id,
+			"run_name":   fmt.Sprintf("Run %s", run.id),
+			"started_at": runTimestamp(),
+			"props":      run.properties(),
+		},
+	}
+}
+
+// finishRunStatement records when the run's writes were done.
+func finishRunStatement(run string) statement {
+	return statement{
+		query: `
+			MATCH (r:Run {id: $run})
+			SET r.finished_at = $finished_at
+		`,
+		params: map[string]interface{}{
+			"run":         run,
+			"finished_at": runTimestamp(),
+		},
+	}
+}
+
+// runTimestamp is the time recorded on Run nodes. Memgraph's timestamp() is
+// in microseconds and Neo4j's in milliseconds, so runs carry their own, in a
+// form that sorts and reads the same on both.
+func runTimestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// osdStatement merges the OSD node and ties it to the run importing it, with
+// props, the OSD's placement as the run saw it, on the IMPORTED relationship.
+func osdStatement(run, osdID string, props map[string]interface{}) statement {
+	return statement{
+		query: `
+			MATCH (r:Run {id: $run})
+			MERGE (o:OSD {id: $osd_id})
+			ON CREATE SET
+				o.created_at = timestamp(),
+				o.name = $osd_name
+			MERGE (r)-[i:IMPORTED]->(o)
+			SET i += $props
+			RETURN o.id as id, o.name as name
+		`,
+		params: map[string]interface{}{
+			"run":      run,
+			"osd_id":   osdID,
+			"osd_name": fmt.Sprintf("osd-%s", osdID),
+			"props":    props,
+		},
+	}
+}
+
+// pgStatement merges a PG node and the OSD's CONTAINS relationship to it, and
+// ties the PG to the run, with the cluster's view of it as the run saw it on
+// the IMPORTED relationship.
+func pgStatement(run, pgID, osdID string, props map[string]interface{}) statement {
+	return statement{
+		query: `
+			MATCH (r:Run {id: $run})
+			MATCH (o:OSD {id: $osd_id})
+			MERGE (p:PG {id: $pg_id})
+			ON CREATE SET
+				p.created_at = timestamp(),
+				p.name = $pg_name
+			MERGE (o)-[:CONTAINS {run: $run}]->(p)
+			MERGE (r)-[i:IMPORTED]->(p)
+			SET i += $pg_props
+			RETURN p.id as pg_id, p.name as pg_name
+		`,
+		params: map[string]interface{}{
+			"run":      run,
+			"osd_id":   osdID,
+			"pg_id":    pgID,
+			"pg_name":  fmt.Sprintf("PG %s", pgID),
+			"pg_props": props,
+		},
+	}
+}
+
+// copyStatement records an OSD's own view of a PG on the run's CONTAINS
+// relationship.
+func copyStatement(run, pgID, osdID string, props map[string]interface{}) statement {
+	return statement{
+		query: `
+			MATCH (o:OSD {id: $osd_id})-[c:CONTAINS {run: $run}]->(p:PG {id: $pg_id})
+			SET c += $props
+		`,
+		params: map[string]interface{}{
+			"run":    run,
+			"osd_id": osdID,
+			"pg_id":  pgID,
+			"props":  props,
+		},
+	}
+}
+
+// objectsStatement merges a batch of an OSD's objects in one of its PGs.
+// Objects are keyed by their full ghobject identity, so clones, generations
+// and EC shards of the same oid stay apart. The nodes are merged one at a
+// time: merging the whole path would create a second Object for every OSD
+// holding a copy, which the uniqueness constraint on Object.id rejects.
+func objectsStatement(run string, objects []listedObject, pgID, osdID string) statement {
+	var batchData []map[string]interface{}
+	for _, obj := range objects {
+		id := obj.Object.FullID()
+		batchData = append(batchData, map[string]interface{}{
+			"object_id":          id,
+			"unique_object_id":   uniqueObjectID(osdID, obj),
+			"object_name":        fmt.Sprintf("Obj %s", obj.Object.ID()),
+			"unique_object_name": fmt.Sprintf("[%s] Obj %s", osdID, obj.Object.ID()),
+			"object_props":       obj.objectProperties(),
+		})
+	}
+
+	return statement{
+		query: `
+			MATCH (o:OSD {id: $osd_id})
+			MATCH (p:PG {id: $pg_id})
+			UNWIND $batch_data AS item
+			MERGE (b:Object {id: item.object_id})
+			ON CREATE SET
+				b.created_at = timestamp(),
+				b.name = item.object_name
+			MERGE (ub:UniqueObject {id: item.unique_object_id})
+			ON CREATE SET
+				ub.created_at = timestamp(),
+				ub.name = item.unique_object_name
+			SET b += item.object_props
+			MERGE (b)-[:IS {run: $run}]->(ub)
+			MERGE (o)-[:CONTAINS {run: $run}]->(ub)
+			MERGE (p)-[:CONTAINS {run: $run}]->(ub)
+			MERGE (p)-[:CONTAINS {run: $run}]->(b)
+		`,
+		params: map[string]interface{}{
+			"run":        run,
+			"osd_id":     osdID,
+			"pg_id":      pgID,
+			"batch_data": batchData,
+		},
+	}
+}
+
+// objectDetailsStatement records what was dumped from an OSD's copies of
+// objects on the run's CONTAINS relationships to them, as copies change
+// between runs.
+func objectDetailsStatement(run string, objects []listedObject, osdID string) statement {
+	var batchData []map[string]interface{}
+	for _, obj := range objects {
+		batchData = append(batchData, map[string]interface{}{
+			"unique_object_id": uniqueObjectID(osdID, obj),
+			"props":            obj.detailProperties(),
+		})
+	}
+
+	return statement{
+		query: `
+			MATCH (o:OSD {id: $osd_id})
+			UNWIND $batch_data AS item
+			MATCH (o)-[c:CONTAINS {run: $run}]->(:UniqueObject {id: item.unique_object_id})
+			SET c += item.props
+		`,
+		params: map[string]interface{}{
+			"run":        run,
+			"osd_id":     osdID,
+			"batch_data": batchData,
+		},
+	}
+}
+
+// uniqueObjectID is the id of an OSD's copy of an object.
+func uniqueObjectID(osdID string, obj listedObject) string {
+	return fmt.Sprintf("%s-%s", osdID, obj.Object.FullID())
+}
+
+var (
+	cypherParamRegexp = regexp.MustCompile(`\$[A-Za-z_][A-Za-z0-9_]*`)
+	whitespaceRegexp  = regexp.MustCompile(`\s+`)
+)
+
+// render returns s as a single line with its parameters inlined as literals,
+// as mgconsole and cypher-shell replay .cypherl files statement by statement.
+// The queries above hold no string literals of their own, so folding their
+// whitespace cannot change a value.
+func (s statement) render() string {
+	query := strings.TrimSpace(whitespaceRegexp.ReplaceAllString(s.query, " "))
+	return cypherParamRegexp.ReplaceAllStringFunc(query, func(param string) string {
+		value, ok := s.params[param[1:]]
+		if !ok {
+			return param
+		}
+		return cypherLiteral(value)
+	}) + ";"
+}
+
+// cypherFloat formats f so that it stays a float, 1.0 rather than 1.
+func cypherFloat(f float64, bitSize int) string {
+	s := strconv.FormatFloat(f, 'g', -1, bitSize)
+	if !strings.ContainsAny(s, ".eEIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// cypherLiteral formats v as a Cypher literal. Map keys are sorted, so the
+// same statement always renders the same way.
+func cypherLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(v) + "'"
+	case bool:
+		return strconv.FormatBool(v)
+	case float32:
+		return cypherFloat(float64(v), 32)
+	case float64:
+		return cypherFloat(v, 64)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Slice, reflect.Array:
+		items := make([]string, rv.Len())
+		for i := range items {
+			items[i] = cypherLiteral(rv.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		var keys []string
+		for _, key := range rv.MapKeys() {
+			keys = append(keys, fmt.Sprint(key.Interface()))
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			items[i] = "`" + strings.ReplaceAll(key, "`", "``") + "`: " + cypherLiteral(rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())).Interface())
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return cypherLiteral(fmt.Sprint(v))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCypherLiteral(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{name: "nil", value: nil, want: "null"},
+		{name: "string", value: "osd-1", want: "'osd-1'"},
+		{name: "quotes", value: `it's "quoted"`, want: `'it\'s "quoted"'`},
+		{name: "backslash", value: `back\slash`, want: `'back\\slash'`},
+		{name: "control characters", value: "a\nb\r\tc", want: `'a\nb\r\tc'`},
+		{name: "bool", value: true, want: "true"},
+		{name: "int", value: -3, want: "-3"},
+		{name: "uint32", value: uint32(4294967295), want: "4294967295"},
+		{name: "whole float", value: 1.0, want: "1.0"},
+		{name: "float", value: 1.5, want: "1.5"},
+		{name: "float32", value: float32(0.25), want: "0.25"},
+		{name: "list", value: []int64{1, 2}, want: "[1, 2]"},
+		{name: "empty list", value: []string{}, want: "[]"},
+		{name: "list with null", value: []interface{}{"a", nil}, want: "['a', null]"},
+		{name: "map", value: map[string]interface{}{"b": 1, "a": "x"}, want: "{`a`: 'x', `b`: 1}"},
+		{name: "map key with a backtick", value: map[string]int{"we`ird": 1}, want: "{`we``ird`: 1}"},
+		{name: "nested", value: []map[string]interface{}{{"l": []string{"it's"}}}, want: `[{` + "`l`" + `: ['it\'s']}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cypherLiteral(tt.value); got != tt.want {
+				t.Errorf("cypherLiteral(%#v) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	objects := testObjects()
+	statements := []statement{
+		osdStatement("abc", "1", testOSDProperties),
+		pgStatement("abc", "1.0", "1", testPGProperties),
+		copyStatement("abc", "1.0", "1", testCopyProperties),
+		objectsStatement("abc", objects, "1.0", "1"),
+		objectDetailsStatement("abc", objects[:1], "1"),
+	}
+
+	var lines []string
+	for _, s := range statements {
+		line := s.render()
+		if strings.Contains(line, "\n") {
+			t.Errorf("rendered statement spans lines: %s", line)
+		}
+		lines = append(lines, line)
+	}
+	checkGolden(t, "statements.cypherl", []byte(strings.Join(lines, "\n")+"\n"))
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// comparison is a report of diff, over the topology before and after.
+type comparison struct {
+	name        string
+	description string
+	columns     []string
+	rows        func(d *topologyDiff) [][]interface{}
+}
+
+// comparisons are diff's reports. All but the first cover only the OSDs
+// found on both sides, so that importing fewer OSDs after than before does not
+// read as every object on the others going missing. Locations are pg@osd.N.
+var comparisons = []comparison{
+	{
+		name:        "osds-not-compared",
+		description: "OSDs found on only one side, left out of the other reports",
+		columns:     []string{"osd", "side"},
+		rows:        (*topologyDiff).uncomparedOSDs,
+	},
+	{
+		name:        "pgs-appeared",
+		description: "PGs an OSD holds after but not before",
+		columns:     []string{"osd", "pg", "objects"},
+		rows:        (*topologyDiff).pgsAppeared,
+	},
+	{
+		name:        "pgs-disappeared",
+		description: "PGs an OSD held before but not after",
+		columns:     []string{"osd", "pg", "objects"},
+		rows:        (*topologyDiff).pgsDisappeared,
+	},
+	{
+		name:        "objects-added",
+		description: "Objects no OSD held before",
+		columns:     []string{"object", "after"},
+		rows:        (*topologyDiff).objectsAdded,
+	},
+	{
+		name:        "objects-removed",
+		description: "Objects no OSD holds after",
+		columns:     []string{"object", "before"},
+		rows:        (*topologyDiff).objectsRemoved,
+	},
+	{
+		name:        "objects-moved",
+		description: "Objects held on both sides whose PGs or OSDs changed",
+		columns:     []string{"object", "before", "after"},
+		rows:        (*topologyDiff).objectsMoved,
+	},
+}
+
+// topologyDiff compares two topologies over the OSDs both have.
+type topologyDiff struct {
+	before, after *topology
+	osds          map[string]bool
+
+	// beforeAt and afterAt hold where each object is on the compared OSDs
+	beforeAt, afterAt map[string][]string
+}
+
+func newTopologyDiff(before, after *topology) *topologyDiff {
+	d := &topologyDiff{before: before, after: after, osds: make(map[string]bool)}
+	for osd := range before.osds {
+		if _, ok := after.osds[osd]; ok {
+			d.osds[osd] = true
+		}
+	}
+	d.beforeAt = d.locations(before)
+	d.afterAt = d.locations(after)
+	return d
+}
+
+// locations returns the sorted locations of each object t holds on the
+// compared OSDs.
+func (d *topologyDiff) locations(t *topology) map[string][]string {
+	at := make(map[string][]string)
+	for pgid, pg := range t.pgs {
+		for osd, objects := range pg.copies {
+			if !d.osds[osd] {
+				continue
+			}
+			for object := range objects {
+				at[object] = append(at[object], pgid+"@osd."+osd)
+			}
+		}
+	}
+	for _, locations := range at {
+		sort.Strings(locations)
+	}
+	return at
+}
+
+func (d *topologyDiff) uncomparedOSDs() [][]interface{} {
+	sides := make(map[string]string)
+	for osd := range d.before.osds {
+		sides[osd] = "before"
+	}
+	for osd := range d.after.osds {
+		sides[osd] = "after"
+	}
+	var rows [][]interface{}
+	for _, osd := range sortedKeys(sides) {
+		if !d.osds[osd] {
+			rows = append(rows, []interface{}{osd, sides[osd]})
+		}
+	}
+	return rows
+}
+
+// pgsOnlyIn returns the copies of PGs that t holds and other does not, on the
+// compared OSDs, with the number of objects in each.
+func (d *topologyDiff) pgsOnlyIn(t, other *topology) [][]interface{} {
+	var rows [][]interface{}
+	for _, osd := range sortedKeys(d.osds) {
+		for _, pgid := range sortedKeys(t.pgs) {
+			objects, ok := t.pgs[pgid].copies[osd]
+			if !ok {
+				continue
+			}
+			if pg := other.pgs[pgid]; pg != nil {
+				if _, ok := pg.copies[osd]; ok {
+					continue
+				}
+			}
+			rows = append(rows, []interface{}{osd, pgid, int64(len(objects))})
+		}
+	}
+	return rows
+}
+
+func (d *topologyDiff) pgsAppeared() [][]interface{} {
+	return d.pgsOnlyIn(d.after, d.before)
+}
+
+func (d *topologyDiff) pgsDisappeared() [][]interface{} {
+	return d.pgsOnlyIn(d.before, d.after)
+}
+
+// objectsOnlyIn returns the objects held on one side and nowhere on the
+// other, with their locations.
+func objectsOnlyIn(at, other map[string][]string) [][]interface{} {
+	var rows [][]interface{}
+	for _, object := range sortedKeys(at) {
+		if _, ok := other[object]; !ok {
+			rows = append(rows, []interface{}{object, stringsToInterfaces(at[object])})
+		}
+	}
+	return rows
+}
+
+func (d *topologyDiff) objectsAdded() [][]interface{} {
+	return objectsOnlyIn(d.afterAt, d.beforeAt)
+}
+
+func (d *topologyDiff) objectsRemoved() [][]interface{} {
+	return objectsOnlyIn(d.beforeAt, d.afterAt)
+}
+
+func (d *topologyDiff) objectsMoved() [][]interface{} {
+	var rows [][]interface{}
+	for _, object := range sortedKeys(d.beforeAt) {
+		before := d.beforeAt[object]
+		after, ok := d.afterAt[object]
+		if ok && strings.Join(before, " ") != strings.Join(after, " ") {
+			rows = append(rows, []interface{}{object, stringsToInterfaces(before), stringsToInterfaces(after)})
+		}
+	}
+	return rows
+}
+
+func stringsToInterfaces(s []string) []interface{} {
+	out := make([]interface{}, len(s))
+	for i, v := range s {
+		out[i] = v
+	}
+	return out
+}
+
+// reports runs comparisons over d.
+func (d *topologyDiff) reports(comparisons []comparison, limit int) []Report {
+	var reports []Report
+	for _, c := range comparisons {
+		a := analysis{name: c.name, description: c.description}
+		reports = append(reports, newReport(a, c.columns, c.rows(d), limit))
+	}
+	return reports
+}
+
+// loadRun builds a topology from what a run imported into Memgraph.
+func (mc *MemgraphClient) loadRun(ctx context.Context, run string) (*topology, error) {
+	params := map[string]interface{}{"run": run}
+	t := newTopology()
+
+	_, rows, err := mc.Query(ctx, "MATCH (:Run {id: $run})-[:IMPORTED]->(o:OSD) RETURN o.id", params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the OSDs of run %s: %v", run, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no run %s, or it imported no OSDs", run)
+	}
+	for _, row := range rows {
+		t.osds[fmt.Sprint(row[0])] = map[string]interface{}{}
+	}
+
+	_, rows, err = mc.Query(ctx, "MATCH (o:OSD)-[:CONTAINS {run: $run}]->(p:PG) RETURN p.id, o.id", params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the PGs of run %s: %v", run, err)
+	}
+	for _, row := range rows {
+		t.copy(fmt.Sprint(row[0]), fmt.Sprint(row[1]))
+	}
+
+	_, rows, err = mc.Query(ctx, `
+		MATCH (p:PG)-[:CONTAINS {run: $run}]->(u:UniqueObject)<-[:CONTAINS {run: $run}]-(o:OSD)
+		MATCH (b:Object)-[:IS {run: $run}]->(u)
+		RETURN p.id, o.id, b.id
+	`, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the objects of run %s: %v", run, err)
+	}
+	for _, row := range rows {
+		t.copy(fmt.Sprint(row[0]), fmt.Sprint(row[1]))[fmt.Sprint(row[2])] = struct{}{}
+	}
+	return t, nil
+}
+
+type diffConfig struct {
+	memgraphConfig
+
+	reports []comparison
+	output  string
+	limit   int
+
+	// before and after are run IDs or, when offline, saved listings given
+	// as for import-file, with osd the OSD of files not named after theirs
+	before  string
+	after   string
+	offline bool
+	osd     string
+}
+
+func parseDiffConfig(args []string, output io.Writer) (*diffConfig, error) {
+	cfg := &diffConfig{}
+	fs := flag.NewFlagSet("ceph-topology-to-memgraph diff", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(output, "Usage: go run ./ceph-topology-to-memgraph diff [flags] <before> <after>")
+		_, _ = fmt.Fprintln(output, "Compares two topologies: two runs imported into Memgraph, or two saved")
+		_, _ = fmt.Fprintln(output, "listings (osd-<id>-pgs.json files or directories, as for import-file).")
+		_, _ = fmt.Fprintln(output, "Reports:")
+		for _, c := range comparisons {
+			_, _ = fmt.Fprintf(output, "  %-18s %s\n", c.name, c.description)
+		}
+		fs.PrintDefaults()
+	}
+
+	report := fs.String("report", "all", "Comma-separated reports to run, or all")
+	fs.StringVar(&cfg.output, "output", "table", "Output format: "+strings.Join(analysisFormats, ", "))
+	fs.IntVar(&cfg.limit, "limit", defaultReportLimit, "Maximum rows per report")
+	fs.StringVar(&cfg.osd, "osd", "", "OSD ID of listing files not named osd-<id>-pgs.json")
+	cfg.memgraphConfig.addFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 2 {
+		return nil, fmt.Errorf("diff takes two runs or saved listings, got %d arguments", fs.NArg())
+	}
+	cfg.before, cfg.after = fs.Arg(0), fs.Arg(1)
+	if cfg.osd != "" {
+		if _, err := strconv.Atoi(cfg.osd); err != nil {
+			return nil, fmt.Errorf("invalid -osd %q", cfg.osd)
+		}
+	}
+
+	if *report == "all" {
+		cfg.reports = comparisons
+	} else {
+		for _, name := range strings.Split(*report, ",") {
+			c, ok := findComparison(strings.TrimSpace(name))
+			if !ok {
+				return nil, fmt.Errorf("unknown report %q", name)
+			}
+			cfg.reports = append(cfg.reports, c)
+		}
+	}
+
+	switch cfg.output {
+	case "table", "json":
+	case "csv":
+		if len(cfg.reports) != 1 {
+			return nil, fmt.Errorf("-output=csv needs a single -report")
+		}
+	default:
+		return nil, fmt.Errorf("unknown output format %q (want one of %s)", cfg.output, strings.Join(analysisFormats, ", "))
+	}
+	if cfg.limit < 1 {
+		return nil, fmt.Errorf("-limit must be at least 1")
+	}
+
+	// Arguments that exist on disk are saved listings; anything else must be
+	// a run
+	_, errBefore := os.Stat(cfg.before)
+	_, errAfter := os.Stat(cfg.after)
+	switch {
+	case errBefore == nil && errAfter == nil:
+		cfg.offline = true
+		return cfg, nil
+	case errBefore == nil || errAfter == nil:
+		return nil, fmt.Errorf("cannot compare a saved listing with a run")
+	}
+	for _, run := range []string{cfg.before, cfg.after} {
+		if !runIDRegexp.MatchString(run) {
+			return nil, fmt.Errorf("%q is neither a file nor a run ID", run)
+		}
+	}
+	if err := cfg.memgraphConfig.resolve(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func findComparison(name string) (comparison, bool) {
+	for _, c := range comparisons {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return comparison{}, false
+}
+
+func runDiff(args []string) int {
+	cfg, err := parseDiffConfig(args, os.Stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	ctx := context.Background()
+	var before, after *topology
+	if cfg.offline {
+		if before, err = loadTopology(ctx, []string{cfg.before}, cfg.osd); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", cfg.before, err)
+			return 1
+		}
+		if after, err = loadTopology(ctx, []string{cfg.after}, cfg.osd); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", cfg.after, err)
+			return 1
+		}
+	} else {
+		logger := log.New(os.Stderr, "", log.LstdFlags)
+		client, err := cfg.memgraphConfig.connect(ctx, logger)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error connecting to Memgraph: %v\n", err)
+			return 1
+		}
+		defer client.Close(ctx)
+
+		if before, err = client.loadRun(ctx, cfg.before); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if after, err = client.loadRun(ctx, cfg.after); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Comparing %s (before) with %s (after)\n", cfg.before, cfg.after)
+	if err := writeReports(os.Stdout, cfg.output, newTopologyDiff(before, after).reports(cfg.reports, cfg.limit)); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error writing reports: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestTopology builds a topology from the objects of each PG on each OSD.
+func newTestTopology(osds map[string]map[string][]string) *topology {
+	t := newTopology()
+	for osd, pgs := range osds {
+		t.osds[osd] = map[string]interface{}{}
+		for pgid, objects := range pgs {
+			held := t.copy(pgid, osd)
+			for _, object := range objects {
+				held[object] = struct{}{}
+			}
+		}
+	}
+	return t
+}
+
+func TestTopologyDiff(t *testing.T) {
+	before := newTestTopology(map[string]map[string][]string{
+		"1": {"1.0": {"a", "b"}, "1.1": {"c"}},
+		"2": {"1.0": {"a", "b", "e"}},
+		"4": {"1.2": {"z"}},
+	})
+	after := newTestTopology(map[string]map[string][]string{
+		"1": {"1.0": {"a"}, "1.2": {"c", "d"}},
+		"2": {"1.0": {"a", "b"}},
+		"5": {"1.3": {"y"}},
+	})
+
+	// OSDs 4 and 5 are each on one side only, so z and y are neither
+	// removed nor added
+	want := map[string][][]interface{}{
+		"osds-not-compared": {
+			{"4", "before"},
+			{"5", "after"},
+		},
+		"pgs-appeared": {
+			{"1", "1.2", int64(2)},
+		},
+		"pgs-disappeared": {
+			{"1", "1.1", int64(1)},
+		},
+		"objects-added": {
+			{"d", []interface{}{"1.2@osd.1"}},
+		},
+		"objects-removed": {
+			{"e", []interface{}{"1.0@osd.2"}},
+		},
+		"objects-moved": {
+			{"b", []interface{}{"1.0@osd.1", "1.0@osd.2"}, []interface{}{"1.0@osd.2"}},
+			{"c", []interface{}{"1.1@osd.1"}, []interface{}{"1.2@osd.1"}},
+		},
+	}
+
+	reports := newTopologyDiff(before, after).reports(comparisons, defaultReportLimit)
+	if len(reports) != len(want) {
+		t.Fatalf("got %d reports, want %d", len(reports), len(want))
+	}
+	for _, r := range reports {
+		if !reflect.DeepEqual(r.values, want[r.Name]) {
+			t.Errorf("%s rows = %v, want %v", r.Name, r.values, want[r.Name])
+		}
+	}
+}
+
+func TestTopologyDiffUnchanged(t *testing.T) {
+	osds := map[string]map[string][]string{
+		"1": {"1.0": {"a", "b"}},
+		"2": {"1.0": {"a"}, "1.1": {"c"}},
+	}
+	for _, r := range newTopologyDiff(newTestTopology(osds), newTestTopology(osds)).reports(comparisons, defaultReportLimit) {
+		if len(r.values) != 0 {
+			t.Errorf("%s rows = %v, want none", r.Name, r.values)
+		}
+	}
+}
+
+func TestParseDiffConfig(t *testing.T) {
+	before, after := t.TempDir(), t.TempDir()
+	tests := []struct {
+		name        string
+		args        []string
+		wantErr     string
+		wantOffline bool
+	}{
+		{name: "saved listings", args: []string{before, after}, wantOffline: true},
+		{name: "runs", args: []string{"0123abcd", "4567ef89"}},
+		{name: "listing then run", args: []string{before, "0123abcd"}, wantErr: "cannot compare a saved listing with a run"},
+		{name: "run then listing", args: []string{"0123abcd", after}, wantErr: "cannot compare a saved listing with a run"},
+		{name: "neither", args: []string{"0123abcd", "no-such-file"}, wantErr: "neither a file nor a run ID"},
+		{name: "one argument", args: []string{before}, wantErr: "got 1 arguments"},
+		{name: "unknown report", args: []string{"-report", "objects-lost", before, after}, wantErr: "unknown report"},
+		{name: "csv of every report", args: []string{"-output", "csv", before, after}, wantErr: "single -report"},
+		{name: "csv of one report", args: []string{"-output", "csv", "-report", "objects-moved", before, after}, wantOffline: true},
+		{name: "bad OSD", args: []string{"-osd", "osd.1", before, after}, wantErr: "invalid -osd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := parseDiffConfig(tt.args, io.Discard)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseDiffConfig() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseDiffConfig() = %v", err)
+			}
+			if cfg.offline != tt.wantOffline {
+				t.Errorf("offline = %v, want %v", cfg.offline, tt.wantOffline)
+			}
+		})
+	}
+}
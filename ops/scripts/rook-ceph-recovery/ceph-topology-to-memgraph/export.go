@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// graphExportSink builds the topology in memory and writes it out as GraphML
+// or as CSV node and edge tables, for tools like Gephi.
+type graphExportSink struct {
+	format string // "graphml" or "csv"
+	path   string // the GraphML file, or the directory for the CSV files
+	run    runInfo
+	graph  *graph
+	logger *log.Logger
+}
+
+// Prepare adds the run's node, as runStatement merges it.
+func (s *graphExportSink) Prepare(ctx context.Context) error {
+	s.graph.mu.Lock()
+	defer s.graph.mu.Unlock()
+	run := s.graph.mergeNode("Run", s.run.id, "Run "+s.run.id)
+	run.props["started_at"] = runTimestamp()
+	setProps(run.props, s.run.properties())
+	return nil
+}
+
+func (s *graphExportSink) newWriter(ctx context.Context) graphWriter {
+	return graphModelWriter{g: s.graph, run: s.run.id}
+}
+
+func (s *graphExportSink) Finish(ctx context.Context) error {
+	s.graph.mu.Lock()
+	defer s.graph.mu.Unlock()
+	s.graph.nodes[nodeRef{label: "Run", id: s.run.id}].props["finished_at"] = runTimestamp()
+
+	var err error
+	var written []string
+	if s.format == "csv" {
+		written, err = writeGraphCSV(s.graph, s.path)
+	} else {
+		err = writeFile(s.path, func(w io.Writer) error { return writeGraphML(s.graph, w) })
+		written = []string{s.path}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s export: %v", s.format, err)
+	}
+
+	s.logger.Printf("Exported %d nodes and %d edges to %s", len(s.graph.nodeOrder), len(s.graph.edgeOrder), strings.Join(written, ", "))
+	fmt.Printf("Exported %d nodes and %d edges to %s\n", len(s.graph.nodeOrder), len(s.graph.edgeOrder), strings.Join(written, ", "))
+	return nil
+}
+
+func (s *graphExportSink) Close(ctx context.Context) error {
+	return nil
+}
+
+// writeFile creates path and writes it with write.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// nodeID is a node's id in exports: ids are only unique within a label.
+func nodeID(ref nodeRef) string {
+	return ref.label + ":" + ref.id
+}
+
+// exportValue formats a property value for an export, with its GraphML type.
+// Lists become comma-separated strings, which Gephi can at least show.
+func exportValue(v interface{}) (string, string) {
+	switch v := v.(type) {
+	case string:
+		return v, "string"
+	case bool:
+		return strconv.FormatBool(v), "boolean"
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), "double"
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), "double"
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), "long"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), "long"
+	case reflect.Slice, reflect.Array:
+		items := make([]string, rv.Len())
+		for i := range items {
+			items[i], _ = exportValue(rv.Index(i).Interface())
+		}
+		return strings.Join(items, ","), "string"
+	case reflect.Map:
+		data, _ := json.Marshal(v)
+		return string(data), "string"
+	}
+	return fmt.Sprint(v), "string"
+}
+
+// propertyTypes returns the properties set on any of props, sorted, with the
+// GraphML type of each. A property holding values of different types is a
+// string.
+func propertyTypes(props []map[string]interface{}) ([]string, map[string]string) {
+	types := make(map[string]string)
+	for _, p := range props {
+		for k, v := range p {
+			_, t := exportValue(v)
+			if prev, ok := types[k]; ok && prev != t {
+				t = "string"
+			}
+			types[k] = t
+		}
+	}
+	names := make([]string, 0, len(types))
+	for k := range types {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names, types
+}
+
+func (g *graph) nodeProps() []map[string]interface{} {
+	props := make([]map[string]interface{}, len(g.nodeOrder))
+	for i, n := range g.nodeOrder {
+		props[i] = n.props
+	}
+	return props
+}
+
+func (g *graph) edgeProps() []map[string]interface{} {
+	props := make([]map[string]interface{}, len(g.edgeOrder))
+	for i, e := range g.edgeOrder {
+		props[i] = e.props
+	}
+	return props
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// writeGraphML writes g as a directed GraphML graph. Each node's label and
+// each edge's type are attributes of their own, next to the properties.
+func writeGraphML(g *graph, w io.Writer) error {
+	nodeNames, nodeTypes := propertyTypes(g.nodeProps())
+	edgeNames, edgeTypes := propertyTypes(g.edgeProps())
+
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	b.WriteString(`  <key id="label" for="node" attr.name="label" attr.type="string"/>` + "\n")
+	for i, name := range nodeNames {
+		fmt.Fprintf(&b, "  <key id=\"n%d\" for=\"node\" attr.name=\"%s\" attr.type=\"%s\"/>\n", i, xmlEscape(name), nodeTypes[name])
+	}
+	b.WriteString(`  <key id="type" for="edge" attr.name="type" attr.type="string"/>` + "\n")
+	for i, name := range edgeNames {
+		fmt.Fprintf(&b, "  <key id=\"e%d\" for=\"edge\" attr.name=\"%s\" attr.type=\"%s\"/>\n", i, xmlEscape(name), edgeTypes[name])
+	}
+	b.WriteString(`  <graph id="ceph" edgedefault="directed">` + "\n")
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+
+	for _, n := range g.nodeOrder {
+		b.Reset()
+		fmt.Fprintf(&b, "    <node id=\"%s\">\n", xmlEscape(nodeID(n.ref)))
+		fmt.Fprintf(&b, "      <data key=\"label\">%s</data>\n", xmlEscape(n.ref.label))
+		for i, name := range nodeNames {
+			if v, ok := n.props[name]; ok {
+				value, _ := exportValue(v)
+				fmt.Fprintf(&b, "      <data key=\"n%d\">%s</data>\n", i, xmlEscape(value))
+			}
+		}
+		b.WriteString("    </node>\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range g.edgeOrder {
+		b.Reset()
+		fmt.Fprintf(&b, "    <edge source=\"%s\" target=\"%s\">\n", xmlEscape(nodeID(e.ref.from)), xmlEscape(nodeID(e.ref.to)))
+		fmt.Fprintf(&b, "      <data key=\"type\">%s</data>\n", xmlEscape(e.ref.kind))
+		for i, name := range edgeNames {
+			if v, ok := e.props[name]; ok {
+				value, _ := exportValue(v)
+				fmt.Fprintf(&b, "      <data key=\"e%d\">%s</data>\n", i, xmlEscape(value))
+			}
+		}
+		b.WriteString("    </edge>\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, "  </graph>\n</graphml>\n")
+	return err
+}
+
+// writeGraphCSV writes g as nodes.csv and edges.csv in dir, with the columns
+// Gephi's spreadsheet import recognises (Id and Label; Source, Target and
+// Type) ahead of the properties. Gephi matches column names regardless of
+// case, so the id and name properties only appear as Id and Label.
+func writeGraphCSV(g *graph, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	nodesPath := filepath.Join(dir, "nodes.csv")
+	edgesPath := filepath.Join(dir, "edges.csv")
+
+	var nodeNames []string
+	names, _ := propertyTypes(g.nodeProps())
+	for _, name := range names {
+		if name != "id" && name != "name" {
+			nodeNames = append(nodeNames, name)
+		}
+	}
+	err := writeFile(nodesPath, func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		if err := cw.Write(append([]string{"Id", "Label", "kind"}, nodeNames...)); err != nil {
+			return err
+		}
+		for _, n := range g.nodeOrder {
+			name, _ := exportValue(n.props["name"])
+			row := []string{nodeID(n.ref), name, n.ref.label}
+			for _, prop := range nodeNames {
+				row = append(row, csvValue(n.props, prop))
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	edgeNames, _ := propertyTypes(g.edgeProps())
+	err = writeFile(edgesPath, func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		if err := cw.Write(append([]string{"Source", "Target", "Type", "Label"}, edgeNames...)); err != nil {
+			return err
+		}
+		for _, e := range g.edgeOrder {
+			row := []string{nodeID(e.ref.from), nodeID(e.ref.to), "Directed", e.ref.kind}
+			for _, prop := range edgeNames {
+				row = append(row, csvValue(e.props, prop))
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []string{nodesPath, edgesPath}, nil
+}
+
+func csvValue(props map[string]interface{}, name string) string {
+	v, ok := props[name]
+	if !ok {
+		return ""
+	}
+	value, _ := exportValue(v)
+	return value
+}
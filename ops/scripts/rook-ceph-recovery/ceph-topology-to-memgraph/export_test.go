@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"main/internal/ceph"
+	"main/internal/objectstore"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// checkGolden compares got with the golden file name, or rewrites the file
+// with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (rerun with -update to accept it):\n%s", path, got)
+	}
+}
+
+// testObjects are objects of PG 1.0 whose names need escaping in every
+// output. The first is dumped.
+func testObjects() []listedObject {
+	object := func(oid, key, namespace string, snapid int64) listedObject {
+		return listedObject{Entry: objectstore.Entry{PGID: "1.0", Object: objectstore.Object{
+			OID: oid, Key: key, Namespace: namespace, SnapID: snapid, Pool: 1,
+			ShardID: objectstore.NoShard, Generation: objectstore.NoGen,
+		}}}
+	}
+	objects := []listedObject{
+		object(`it's "quoted"`, "", "", objectstore.NoSnap),
+		object("back\\slash\nnew line", "k", "ns", objectstore.NoSnap),
+		object("<tag> & </tag>", "", "", 4),
+	}
+	objects[0].Dump = &objectstore.Dump{}
+	objects[0].Dump.Info.Version = ceph.Eversion{Epoch: 120, Version: 46}
+	objects[0].Dump.Info.UserVersion = 46
+	objects[0].Dump.Info.Size = 4096
+	objects[0].Dump.Info.Mtime = "2024-01-02T03:04:05.000000+0000"
+	objects[0].Dump.Stat.Size = 4096
+	return objects
+}
+
+// testOSDProperties has a placement property unset, which removes it.
+var testOSDProperties = map[string]interface{}{"host": "node-a", "crush_weight": 1.5, "up": true, "device_class": nil}
+
+var testPGProperties = map[string]interface{}{"pool_id": 1, "acting": []int64{1, 2}, "state": "active+clean"}
+
+var testCopyProperties = map[string]interface{}{"last_update": "120'46", "num_objects": int64(3)}
+
+// testGraph is a small run as the import writes it into a graph.
+func testGraph(t *testing.T) *graph {
+	g := newGraph()
+	run := g.mergeNode("Run", "abc", "Run abc")
+	run.props["note"] = `before "recovery"`
+
+	ctx := context.Background()
+	w := graphModelWriter{g: g, run: "abc"}
+	objects := testObjects()
+	for _, err := range []error{
+		w.CreateOSDNode(ctx, "1", testOSDProperties),
+		w.MergePG(ctx, "1.0", "1", testPGProperties),
+		w.SetCopyProperties(ctx, "1.0", "1", testCopyProperties),
+		w.WriteObjects(ctx, objects, "1.0", "1"),
+		w.SetObjectDetails(ctx, objects[:1], "1"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeGraphML(testGraph(t), &b); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "graph.graphml", b.Bytes())
+
+	d := xml.NewDecoder(bytes.NewReader(b.Bytes()))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("GraphML is not well-formed: %v", err)
+		}
+	}
+}
+
+func TestWriteGraphCSV(t *testing.T) {
+	dir := t.TempDir()
+	written, err := writeGraphCSV(testGraph(t), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 2 {
+		t.Fatalf("wrote %q, want nodes.csv and edges.csv", written)
+	}
+
+	for _, name := range []string{"nodes.csv", "edges.csv"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, name, data)
+
+		if name != "nodes.csv" {
+			continue
+		}
+		rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			t.Fatalf("%s does not parse: %v", name, err)
+		}
+		found := false
+		for _, row := range rows {
+			found = found || row[1] == "Obj ns/back\\slash\nnew line#k"
+		}
+		if !found {
+			t.Errorf("%s lost the name of an object with a newline", name)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// nodeRef identifies a node by its label and id, as the importer's MERGEs do.
+type nodeRef struct {
+	label string
+	id    string
+}
+
+type graphNode struct {
+	ref   nodeRef
+	props map[string]interface{}
+}
+
+type edgeRef struct {
+	kind string
+	from nodeRef
+	to   nodeRef
+}
+
+type graphEdge struct {
+	ref   edgeRef
+	props map[string]interface{}
+}
+
+// graph is an in-memory property graph, holding what the import's Cypher
+// statements would leave in a database. Nodes and edges keep the order they
+// were first merged in.
+type graph struct {
+	mu        sync.Mutex
+	nodes     map[nodeRef]*graphNode
+	nodeOrder []*graphNode
+	edges     map[edgeRef]*graphEdge
+	edgeOrder []*graphEdge
+}
+
+func newGraph() *graph {
+	return &graph{
+		nodes: make(map[nodeRef]*graphNode),
+		edges: make(map[edgeRef]*graphEdge),
+	}
+}
+
+// mergeNode returns the node, creating it with id and name if it is new.
+func (g *graph) mergeNode(label, id, name string) *graphNode {
+	ref := nodeRef{label: label, id: id}
+	if n := g.nodes[ref]; n != nil {
+		return n
+	}
+	n := &graphNode{ref: ref, props: map[string]interface{}{"id": id, "name": name}}
+	g.nodes[ref] = n
+	g.nodeOrder = append(g.nodeOrder, n)
+	return n
+}
+
+func (g *graph) mergeEdge(kind string, from, to nodeRef) *graphEdge {
+	ref := edgeRef{kind: kind, from: from, to: to}
+	if e := g.edges[ref]; e != nil {
+		return e
+	}
+	e := &graphEdge{ref: ref, props: make(map[string]interface{})}
+	g.edges[ref] = e
+	g.edgeOrder = append(g.edgeOrder, e)
+	return e
+}
+
+// setProps is SET dst += src: null values remove the property.
+func setProps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+		} else {
+			dst[k] = v
+		}
+	}
+}
+
+// graphModelWriter applies writes to g the way the Cypher statements apply
+// them to a database, MATCHes that find nothing included.
+type graphModelWriter struct {
+	g   *graph
+	run string
+}
+
+// edge merges a relationship of the run, tagged with its id.
+func (w graphModelWriter) edge(kind string, from, to nodeRef) *graphEdge {
+	e := w.g.mergeEdge(kind, from, to)
+	e.props["run"] = w.run
+	return e
+}
+
+func (w graphModelWriter) CreateOSDNode(ctx context.Context, osdID string, props map[string]interface{}) error {
+	w.g.mu.Lock()
+	defer w.g.mu.Unlock()
+	run := w.g.nodes[nodeRef{label: "Run", id: w.run}]
+	if run == nil {
+		return nil
+	}
+	osd := w.g.mergeNode("OSD", osdID, "osd-"+osdID)
+	setProps(w.g.mergeEdge("IMPORTED", run.ref, osd.ref).props, props)
+	return nil
+}
+
+func (w graphModelWriter) MergePG(ctx context.Context, pgID, osdID string, props map[string]interface{}) error {
+	w.g.mu.Lock()
+	defer w.g.mu.Unlock()
+	run := w.g.nodes[nodeRef{label: "Run", id: w.run}]
+	osd := w.g.nodes[nodeRef{label: "OSD", id: osdID}]
+	if run == nil || osd == nil {
+		return nil
+	}
+	pg := w.g.mergeNode("PG", pgID, "PG "+pgID)
+	w.edge("CONTAINS", osd.ref, pg.ref)
+	setProps(w.g.mergeEdge("IMPORTED", run.ref, pg.ref).props, props)
+	return nil
+}
+
+func (w graphModelWriter) SetCopyProperties(ctx context.Context, pgID, osdID string, props map[string]interface{}) error {
+	w.g.mu.Lock()
+	defer w.g.mu.Unlock()
+	ref := edgeRef{kind: "CONTAINS", from: nodeRef{label: "OSD", id: osdID}, to: nodeRef{label: "PG", id: pgID}}
+	if e := w.g.edges[ref]; e != nil {
+		setProps(e.props, props)
+	}
+	return nil
+}
+
+func (w graphModelWriter) WriteObjects(ctx context.Context, objects []listedObject, pgID, osdID string) error {
+	w.g.mu.Lock()
+	defer w.g.mu.Unlock()
+	osd := w.g.nodes[nodeRef{label: "OSD", id: osdID}]
+	pg := w.g.nodes[nodeRef{label: "PG", id: pgID}]
+	if osd == nil || pg == nil {
+		return nil
+	}
+	for _, obj := range objects {
+		b := w.g.mergeNode("Object", obj.Object.FullID(), "Obj "+obj.Object.ID())
+		ub := w.g.mergeNode("UniqueObject", uniqueObjectID(osdID, obj), "["+osdID+"] Obj "+obj.Object.ID())
+		setProps(b.props, obj.objectProperties())
+		w.edge("IS", b.ref, ub.ref)
+		w.edge("CONTAINS", osd.ref, ub.ref)
+		w.edge("CONTAINS", pg.ref, ub.ref)
+		w.edge("CONTAINS", pg.ref, b.ref)
+	}
+	return nil
+}
+
+func (w graphModelWriter) SetObjectDetails(ctx context.Context, objects []listedObject, osdID string) error {
+	w.g.mu.Lock()
+	defer w.g.mu.Unlock()
+	osd := nodeRef{label: "OSD", id: osdID}
+	for _, obj := range objects {
+		ref := edgeRef{kind: "CONTAINS", from: osd, to: nodeRef{label: "UniqueObject", id: uniqueObjectID(osdID, obj)}}
+		if e := w.g.edges[ref]; e != nil {
+			setProps(e.props, obj.detailProperties())
+		}
+	}
+	return nil
+}
+
+func (w graphModelWriter) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGraphModelKeepsRunsApart(t *testing.T) {
+	g := newGraph()
+	ctx := context.Background()
+	runs := []struct{ id, host, state string }{
+		{"before", "node-a", "down"},
+		{"after", "node-b", "active+clean"},
+	}
+	for _, run := range runs {
+		g.mergeNode("Run", run.id, "Run "+run.id)
+		w := graphModelWriter{g: g, run: run.id}
+		if err := w.CreateOSDNode(ctx, "1", map[string]interface{}{"host": run.host}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.MergePG(ctx, "1.0", "1", map[string]interface{}{"state": run.state}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	osd, pg := nodeRef{label: "OSD", id: "1"}, nodeRef{label: "PG", id: "1.0"}
+	for _, ref := range []nodeRef{osd, pg} {
+		if props := g.nodes[ref].props; len(props) != 2 {
+			t.Errorf("%s %s props = %v, want only id and name", ref.label, ref.id, props)
+		}
+	}
+	for _, run := range runs {
+		from := nodeRef{label: "Run", id: run.id}
+		if got := g.edges[edgeRef{kind: "IMPORTED", from: from, to: osd}].props["host"]; got != run.host {
+			t.Errorf("host of OSD 1 in run %s = %v, want %s", run.id, got, run.host)
+		}
+		if got := g.edges[edgeRef{kind: "IMPORTED", from: from, to: pg}].props["state"]; got != run.state {
+			t.Errorf("state of PG 1.0 in run %s = %v, want %s", run.id, got, run.state)
+		}
+	}
+}
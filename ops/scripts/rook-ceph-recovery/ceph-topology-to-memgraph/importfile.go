@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"main/internal/ceph"
+	"main/internal/executor"
+)
+
+// Saved files are recognised by the names this tool and reconcile-dodgy-pgs
+// give them.
+var (
+	osdListingRegexp = regexp.MustCompile(`^osd-([0-9]+)-pgs\.json$`)
+	pgListingRegexp  = regexp.MustCompile(`^pg_([0-9]+\.[0-9a-f]+(?:s[0-9]+)?)_osd_([0-9]+)_list\.json$`)
+	pgInfoRegexp     = regexp.MustCompile(`^pg_([0-9]+\.[0-9a-f]+(?:s[0-9]+)?)_osd_([0-9]+)\.json$`)
+	pgQueryRegexp    = regexp.MustCompile(`^pg_([0-9]+\.[0-9a-f]+(?:s[0-9]+)?)_cluster\.json$`)
+	metadataFiles    = map[string][]string{
+		"osd_tree.json": ceph.OSDTreeCommand(),
+		"pg_dump.json":  ceph.PGDumpCommand(),
+		"lspools.json":  ceph.PoolsCommand(),
+		"fsid.json":     ceph.FSIDCommand(),
+	}
+)
+
+func parseImportFileConfig(args []string, output io.Writer) (*config, []string, string, error) {
+	cfg := &config{namespace: "rook-ceph"}
+	fs := flag.NewFlagSet("ceph-topology-to-memgraph import-file", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(output, "Usage: go run ./ceph-topology-to-memgraph import-file [flags] <file or directory>...")
+		_, _ = fmt.Fprintln(output, "Imports saved listings without cluster access. Recognised files:")
+		_, _ = fmt.Fprintln(output, "  osd-<id>-pgs.json            --op list of a whole OSD (saved by an import)")
+		_, _ = fmt.Fprintln(output, "  pg_<pg>_osd_<id>_list.json   --op list of one PG (saved by reconcile-dodgy-pgs)")
+		_, _ = fmt.Fprintln(output, "  pg_<pg>_osd_<id>.json        --op info of one PG on one OSD")
+		_, _ = fmt.Fprintln(output, "  pg_<pg>_cluster.json         ceph pg <pg> query")
+		_, _ = fmt.Fprintln(output, "  osd_tree.json, pg_dump.json, lspools.json, fsid.json")
+		_, _ = fmt.Fprintln(output, "Other files are read as a listing of the OSD given by -osd.")
+		fs.PrintDefaults()
+	}
+
+	osd := fs.String("osd", "", "OSD ID of listing files not named osd-<id>-pgs.json")
+	fs.IntVar(&cfg.concurrency, "concurrency", 3, "Number of listings to parse at once")
+	cfg.memgraphConfig.addFlags(fs)
+	cfg.writeConfig.addFlags(fs)
+	fs.StringVar(&cfg.logDir, "log-dir", os.TempDir(), "Directory for the log file and copies of the imported listings")
+	fs.StringVar(&cfg.resume, "resume", "", "Resume the run with this hash, skipping committed batches")
+	fs.BoolVar(&cfg.dryRun, "dry-run", false, "Parse the files but do not connect to or write to Memgraph")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, "", err
+	}
+	if fs.NArg() == 0 {
+		return nil, nil, "", fmt.Errorf("at least one file or directory is required")
+	}
+	if *osd != "" {
+		if _, err := strconv.Atoi(*osd); err != nil {
+			return nil, nil, "", fmt.Errorf("invalid -osd %q", *osd)
+		}
+	}
+	if cfg.concurrency < 1 {
+		return nil, nil, "", fmt.Errorf("-concurrency must be at least 1")
+	}
+	if err := cfg.writeConfig.validate(); err != nil {
+		return nil, nil, "", err
+	}
+	if cfg.resume != "" && !runIDRegexp.MatchString(cfg.resume) {
+		return nil, nil, "", fmt.Errorf("invalid -resume %q: expected the hash printed by an earlier run", cfg.resume)
+	}
+	if err := cfg.memgraphConfig.resolve(); err != nil {
+		return nil, nil, "", err
+	}
+	if err := os.MkdirAll(cfg.logDir, 0755); err != nil {
+		return nil, nil, "", fmt.Errorf("creating log directory: %v", err)
+	}
+
+	return cfg, fs.Args(), *osd, nil
+}
+
+// capture is a set of saved files, registered on a fixture under the
+// commands a live import would run to produce them.
+type capture struct {
+	fixture *executor.Fixture
+	ns      string
+	osd     string
+
+	// listings holds the listing files of each OSD. A whole-OSD listing
+	// replaces any per-PG ones.
+	listings map[int][]string
+	whole    map[int]bool
+	infos    map[int]map[string]string
+	queries  []ceph.PGQuery
+	metadata bool
+}
+
+// newCapture returns an empty capture. Listings not named after their OSD
+// are taken to be of osd.
+func newCapture(ns, osd string) *capture {
+	return &capture{
+		fixture:  executor.NewFixture(""),
+		ns:       ns,
+		osd:      osd,
+		listings: make(map[int][]string),
+		whole:    make(map[int]bool),
+		infos:    make(map[int]map[string]string),
+	}
+}
+
+func runImportFile(args []string) int {
+	cfg, paths, osd, err := parseImportFileConfig(args, os.Stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	c := newCapture(cfg.namespace, osd)
+	for _, path := range paths {
+		if err := c.add(path); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+	}
+
+	targets, err := c.register()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	cfg.placement = c.metadata
+	cfg.pgInfo = len(c.infos) > 0
+
+	return runImport(context.Background(), cfg, c.fixture, targets, c.queries)
+}
+
+// add adds a file, or every recognised file in a directory.
+func (c *capture) add(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return c.addFile(path, true)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := c.addFile(filepath.Join(path, entry.Name()), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFile adds one file by its name. Files given explicitly that match no
+// known name are listings of the -osd OSD; in directories they are skipped.
+func (c *capture) addFile(path string, explicit bool) error {
+	name := filepath.Base(path)
+	switch {
+	case osdListingRegexp.MatchString(name):
+		osd, _ := strconv.Atoi(osdListingRegexp.FindStringSubmatch(name)[1])
+		if !c.whole[osd] {
+			c.listings[osd] = nil
+		}
+		c.whole[osd] = true
+		c.listings[osd] = append(c.listings[osd], path)
+
+	case pgListingRegexp.MatchString(name):
+		osd, _ := strconv.Atoi(pgListingRegexp.FindStringSubmatch(name)[2])
+		if !c.whole[osd] {
+			c.listings[osd] = append(c.listings[osd], path)
+		}
+
+	case pgInfoRegexp.MatchString(name):
+		m := pgInfoRegexp.FindStringSubmatch(name)
+		osd, _ := strconv.Atoi(m[2])
+		if c.infos[osd] == nil {
+			c.infos[osd] = make(map[string]string)
+		}
+		c.infos[osd][m[1]] = path
+
+	case pgQueryRegexp.MatchString(name):
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var q ceph.PGQuery
+		if err := json.Unmarshal(data, &q); err != nil {
+			return fmt.Errorf("parsing %s: %v", path, err)
+		}
+		if q.Info.PGID == "" {
+			q.Info.PGID = pgQueryRegexp.FindStringSubmatch(name)[1]
+		}
+		c.queries = append(c.queries, q)
+
+	case metadataFiles[name] != nil:
+		cmd := metadataFiles[name]
+		c.fixture.File(path, cmd[0], cmd[1:]...)
+		c.metadata = true
+
+	case explicit:
+		if c.osd == "" {
+			return fmt.Errorf("cannot tell which OSD %s was listed from; pass -osd", path)
+		}
+		osd, _ := strconv.Atoi(c.osd)
+		c.listings[osd] = append(c.listings[osd], path)
+	}
+	return nil
+}
+
+// register registers the listings and PG info under the commands a live
+// import would run for them, and returns one target per OSD. The "pod" of
+// each target is the first of its files, which is what the summary shows.
+func (c *capture) register() ([]osdTarget, error) {
+	if len(c.listings) == 0 {
+		return nil, fmt.Errorf("no object listings found")
+	}
+
+	var osds []int
+	for osd := range c.listings {
+		osds = append(osds, osd)
+	}
+	sort.Ints(osds)
+
+	var targets []osdTarget
+	for _, osd := range osds {
+		files := c.listings[osd]
+		sort.Strings(files)
+		pod := files[0]
+
+		// A whole-OSD listing is streamed from disk; per-PG listings are
+		// small enough to join in memory
+		cmd := ceph.ListCommand(c.ns, pod, osd, "")
+		if len(files) == 1 {
+			c.fixture.File(pod, cmd[0], cmd[1:]...)
+		} else {
+			var listing []string
+			for _, file := range files {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return nil, err
+				}
+				listing = append(listing, strings.TrimSpace(string(data)))
+			}
+			c.fixture.Output(strings.Join(listing, "\n"), cmd[0], cmd[1:]...)
+		}
+
+		for pgid, file := range c.infos[osd] {
+			cmd := ceph.InfoCommand(c.ns, pod, osd, pgid)
+			c.fixture.File(file, cmd[0], cmd[1:]...)
+		}
+
+		targets = append(targets, osdTarget{id: strconv.Itoa(osd), pod: pod})
+	}
+	return targets, nil
+}
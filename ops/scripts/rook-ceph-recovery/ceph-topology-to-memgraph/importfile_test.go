@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImportFile(t *testing.T) {
+	capture := filepath.Join("testdata", "import")
+	c := newCapture("rook-ceph", "")
+	if err := c.add(capture); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := c.register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTargets := []osdTarget{
+		{id: "1", pod: filepath.Join(capture, "osd-1-pgs.json")},
+		{id: "2", pod: filepath.Join(capture, "osd-2-pgs.json")},
+		{id: "3", pod: filepath.Join(capture, "pg_1.1_osd_3_list.json")},
+	}
+	if !reflect.DeepEqual(targets, wantTargets) {
+		t.Fatalf("targets = %+v, want %+v", targets, wantTargets)
+	}
+
+	// As runImport does for import-file
+	ctx := context.Background()
+	dir := t.TempDir()
+	meta := loadClusterMetadata(ctx, c.fixture, dir, testLogger())
+	meta.addQueries(c.queries)
+	cfg := testConfig()
+	cfg.pgInfo = true
+	sink := &topologySink{topology: newTopology(), limit: defaultReportLimit}
+
+	if _, failed := streamTargets(ctx, cfg, c.fixture, sink, targets, meta, nil, dir, false, testLogger()); failed {
+		t.Fatal("import failed")
+	}
+
+	if got := meta.pgProperties("1.0")["state"]; got != "active+recovery_wait+degraded" {
+		t.Errorf("state of PG 1.0 = %v, want the state from its query", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pg_1.0_osd_1.json")); err != nil {
+		t.Errorf("PG info of 1.0 on OSD 1 was not replayed: %v", err)
+	}
+
+	topo := sink.topology
+	for osd, host := range map[string]string{"1": "node-a", "2": "node-a", "3": "node-b"} {
+		if got := topo.osds[osd]["host"]; got != host {
+			t.Errorf("host of OSD %s = %v, want %s", osd, got, host)
+		}
+	}
+	wantCopies := map[string]map[string]objectSet{
+		"1.0": {
+			"1": {"1:a": {}, "1:b": {}, "1:c": {}},
+			"2": {"1:a": {}, "1:b": {}},
+		},
+		"1.1": {
+			"1": {"1:d": {}, "1:f": {}},
+			"2": {"1:d": {}},
+			"3": {"1:d": {}, "1:e": {}},
+		},
+		"2.1as0": {"1": {"2:x:s0": {}}},
+		"2.1as1": {"2": {"2:x:s1": {}}},
+	}
+	copies := make(map[string]map[string]objectSet)
+	for pgid, pg := range topo.pgs {
+		copies[pgid] = pg.copies
+	}
+	if !reflect.DeepEqual(copies, wantCopies) {
+		t.Errorf("copies = %v, want %v", copies, wantCopies)
+	}
+
+	want := map[string][][]interface{}{
+		"single-copy": {
+			{"1.0", "1:c", "1", "node-a"},
+			{"1.1", "1:e", "3", "node-b"},
+			{"1.1", "1:f", "1", "node-a"},
+		},
+		"pg-object-sets": {
+			{"1.0", "2", int64(2), int64(3), int64(1)},
+			{"1.1", "1", int64(2), int64(3), int64(1)},
+			{"1.1", "2", int64(1), int64(3), int64(2)},
+			{"1.1", "3", int64(2), int64(3), int64(1)},
+		},
+		"copy-mismatch": nil,
+		"no-surviving-replica": {
+			{"1.1", int64(3), int64(2), []interface{}{"1=2", "2=1", "3=2"}},
+		},
+	}
+	for _, r := range topo.reports(analyses, defaultReportLimit) {
+		if !reflect.DeepEqual(r.values, want[r.Name]) {
+			t.Errorf("%s rows = %v, want %v", r.Name, r.values, want[r.Name])
+		}
+	}
+}
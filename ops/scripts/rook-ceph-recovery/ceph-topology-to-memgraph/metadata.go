@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"main/internal/ceph"
+	"main/internal/executor"
+)
+
+// clusterMetadata is the cluster's view of where OSDs sit and what state PGs
+// are in, recorded on OSD and PG nodes next to the on-disk listings.
+type clusterMetadata struct {
+	fsid  string
+	osds  map[int]ceph.OSDPlacement
+	pgs   map[string]ceph.PGStat
+	pools map[int]string
+}
+
+func newClusterMetadata() *clusterMetadata {
+	return &clusterMetadata{
+		osds:  make(map[int]ceph.OSDPlacement),
+		pgs:   make(map[string]ceph.PGStat),
+		pools: make(map[int]string),
+	}
+}
+
+// loadClusterMetadata reads the fsid, OSD tree, PG dump and pool list through the
+// rook-ceph plugin, saving each output in dir. The monitors may well be down
+// while recovering, so each part that cannot be read is reported and left out
+// rather than failing the import.
+func loadClusterMetadata(ctx context.Context, ex executor.Executor, dir string, logger *log.Logger) *clusterMetadata {
+	m := newClusterMetadata()
+
+	read := func(cmd []string, file string) []byte {
+		out, err := ex.Run(ctx, cmd[0], cmd[1:]...)
+		if err != nil {
+			logger.Printf("Warning: %v", err)
+			fmt.Printf("Warning: cluster metadata incomplete: %v\n", err)
+			return nil
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), out, 0644); err != nil {
+			logger.Printf("Warning: failed to save %s: %v", file, err)
+		}
+		return out
+	}
+	warn := func(what string, err error) {
+		logger.Printf("Warning: parsing %s: %v", what, err)
+		fmt.Printf("Warning: cluster metadata incomplete: parsing %s: %v\n", what, err)
+	}
+
+	if out := read(ceph.FSIDCommand(), "fsid.json"); out != nil {
+		var fsid ceph.FSID
+		if err := json.Unmarshal(out, &fsid); err != nil {
+			warn("fsid", err)
+		} else {
+			m.fsid = fsid.FSID
+		}
+	}
+
+	if out := read(ceph.OSDTreeCommand(), "osd_tree.json"); out != nil {
+		var tree ceph.OSDTree
+		if err := json.Unmarshal(out, &tree); err != nil {
+			warn("OSD tree", err)
+		} else {
+			m.osds = tree.Placements()
+		}
+	}
+
+	if out := read(ceph.PGDumpCommand(), "pg_dump.json"); out != nil {
+		if stats, err := ceph.ParsePGStats(out); err != nil {
+			warn("PG dump", err)
+		} else {
+			for _, stat := range stats {
+				m.pgs[stat.PGID] = stat
+			}
+		}
+	}
+
+	if out := read(ceph.PoolsCommand(), "lspools.json"); out != nil {
+		var pools []ceph.Pool
+		if err := json.Unmarshal(out, &pools); err != nil {
+			warn("pool list", err)
+		} else {
+			for _, pool := range pools {
+				m.pools[pool.ID] = pool.Name
+			}
+		}
+	}
+
+	logger.Printf("Cluster metadata: fsid %s, %d OSDs, %d PGs, %d pools", m.fsid, len(m.osds), len(m.pgs), len(m.pools))
+	return m
+}
+
+// addQueries fills in PGs missing from the PG dump from `ceph pg <id> query`
+// output, such as that saved by reconcile-dodgy-pgs.
+func (m *clusterMetadata) addQueries(queries []ceph.PGQuery) {
+	for _, q := range queries {
+		if _, ok := m.pgs[q.Info.PGID]; !ok {
+			m.pgs[q.Info.PGID] = q.Stat()
+		}
+	}
+}
+
+// osdProperties are the OSD's placement, set on the run's IMPORTED
+// relationship to its node. They are empty when the OSD is not in the tree or
+// no metadata was loaded.
+func (m *clusterMetadata) osdProperties(osdID string) map[string]interface{} {
+	props := make(map[string]interface{})
+	if m == nil {
+		return props
+	}
+	id, err := strconv.Atoi(osdID)
+	if err != nil {
+		return props
+	}
+	p, ok := m.osds[id]
+	if !ok {
+		return props
+	}
+
+	props["host"] = p.Host
+	props["device_class"] = p.DeviceClass
+	props["crush_location"] = p.LocationString()
+	props["crush_weight"] = p.CrushWeight
+	props["reweight"] = p.Reweight
+	props["up"] = p.Up
+	props["in"] = p.In
+	return props
+}
+
+// pgProperties are set on the run's IMPORTED relationship to a PG node: its
+// pool, which is known from the ID alone, and the cluster's view of it if the
+// PG dump has it.
+func (m *clusterMetadata) pgProperties(pgid string) map[string]interface{} {
+	props := make(map[string]interface{})
+	pool, ok := ceph.PoolID(pgid)
+	if !ok {
+		return props
+	}
+	props["pool_id"] = pool
+	if m == nil {
+		return props
+	}
+	if name, ok := m.pools[pool]; ok {
+		props["pool_name"] = name
+	}
+
+	stat, ok := m.pgs[pgid]
+	if !ok {
+		return props
+	}
+	props["state"] = stat.State
+	props["up"] = int64s(stat.Up)
+	props["acting"] = int64s(stat.Acting)
+	props["up_primary"] = stat.UpPrimary
+	props["acting_primary"] = stat.ActingPrimary
+	props["last_update"] = stat.Version.String()
+	props["num_objects"] = stat.StatSum.NumObjects
+	props["num_objects_missing"] = stat.StatSum.NumObjectsMissing
+	props["num_objects_degraded"] = stat.StatSum.NumObjectsDegraded
+	props["num_objects_unfound"] = stat.StatSum.NumObjectsUnfound
+	return props
+}
+
+// copyProperties are the properties set on an OSD's CONTAINS relationship to
+// a PG, from that OSD's own pg_info_t. Each copy has its own log position, so
+// they belong on the relationship rather than on the shared PG node.
+func copyProperties(info *ceph.PGInfo) map[string]interface{} {
+	props := make(map[string]interface{})
+	if info == nil {
+		return props
+	}
+
+	props["last_update"] = info.LastUpdate.String()
+	props["last_complete"] = info.LastComplete.String()
+	props["log_tail"] = info.LogTail.String()
+	props["last_epoch_started"] = info.StartedEpoch()
+	props["state"] = info.Stats.State
+	props["num_objects"] = info.Stats.StatSum.NumObjects
+	props["num_objects_missing"] = info.Stats.StatSum.NumObjectsMissing
+	return props
+}
+
+// readPGInfo runs --op info for a PG on an OSD, saving the output in dir. It
+// returns nil if the info cannot be read, and the PG is imported without it.
+func readPGInfo(ctx context.Context, ex executor.Executor, ns, pod string, osd int, pgid, dir string, logger *log.Logger) *ceph.PGInfo {
+	cmd := ceph.InfoCommand(ns, pod, osd, pgid)
+	out, err := ex.Run(ctx, cmd[0], cmd[1:]...)
+	if err != nil {
+		logger.Printf("Warning: no info for PG %s on OSD %d: %v", pgid, osd, err)
+		return nil
+	}
+
+	file := filepath.Join(dir, fmt.Sprintf("pg_%s_osd_%d.json", pgid, osd))
+	if err := os.WriteFile(file, out, 0644); err != nil {
+		logger.Printf("Warning: failed to save %s: %v", file, err)
+	}
+
+	var info ceph.PGInfo
+	if err := json.Unmarshal(out, &info); err != nil {
+		logger.Printf("Warning: parsing info for PG %s on OSD %d: %v", pgid, osd, err)
+		return nil
+	}
+	return &info
+}
+
+func int64s(ids []int) []int64 {
+	out := make([]int64, len(ids))
+	for i, id := range ids {
+		out[i] = int64(id)
+	}
+	return out
+}
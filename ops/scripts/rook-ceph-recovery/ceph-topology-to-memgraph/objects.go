@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"main/internal/ceph"
+	"main/internal/executor"
+	"main/internal/objectstore"
+)
+
+// listedObject is an object as listed on one OSD, with its dump once it has
+// been dumped for -dump-objects.
+type listedObject struct {
+	objectstore.Entry
+	Dump *objectstore.Dump `json:"dump,omitempty"`
+}
+
+// objectProperties are the ghobject fields set on the Object node. They are
+// the same on every OSD holding the object.
+func (o listedObject) objectProperties() map[string]interface{} {
+	props := map[string]interface{}{
+		"oid":       o.Object.OID,
+		"key":       o.Object.Key,
+		"namespace": o.Object.Namespace,
+		"pool":      o.Object.Pool,
+		"hash":      int64(o.Object.Hash),
+		"snapid":    o.Object.SnapID,
+		"max":       o.Object.Max,
+		"shard_id":  o.Object.ShardID,
+	}
+	if o.Object.Generation != objectstore.NoGen {
+		// Generations above MaxInt64 are not representable as a property
+		props["generation"] = int64(o.Object.Generation)
+	}
+	return props
+}
+
+// detailProperties are set on the run's CONTAINS relationship from the OSD to
+// the UniqueObject, from this OSD's copy of the object. They are empty unless
+// the object was dumped.
+func (o listedObject) detailProperties() map[string]interface{} {
+	props := make(map[string]interface{})
+	if o.Dump == nil {
+		return props
+	}
+
+	props["version"] = o.Dump.Info.Version.String()
+	props["user_version"] = int64(o.Dump.Info.UserVersion)
+	props["size"] = o.Dump.Info.Size
+	props["stored_size"] = o.Dump.Stat.Size
+	props["mtime"] = o.Dump.Info.Mtime
+	props["local_mtime"] = o.Dump.Info.LocalMtime
+	return props
+}
+
+// dumpObject dumps one object on an OSD. It returns nil if the object cannot
+// be dumped, and it is imported without the details.
+func dumpObject(ctx context.Context, ex executor.Executor, ns, pod string, osd int, entry objectstore.Entry, logger *log.Logger) *objectstore.Dump {
+	cmd := ceph.DumpCommand(ns, pod, osd, entry.PGID, entry.Raw)
+	out, err := ex.Run(ctx, cmd[0], cmd[1:]...)
+	if err != nil {
+		logger.Printf("Warning: cannot dump %s on OSD %d: %v", entry.Object.FullID(), osd, err)
+		return nil
+	}
+	dump, err := objectstore.ParseDump(out)
+	if err != nil {
+		logger.Printf("Warning: parsing dump of %s on OSD %d: %v", entry.Object.FullID(), osd, err)
+		return nil
+	}
+	return &dump
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// runInfo identifies an import. Every relationship an import merges carries
+// its id or starts from its Run node, so imports of the same OSDs, say before
+// and after a recovery, stay apart in the graph.
+type runInfo struct {
+	id   string
+	fsid string // the cluster's fsid, when its metadata was read
+	note string
+}
+
+// properties are the Run node's properties besides its id, name and times.
+// Unset ones are left out, so that resuming without them keeps those recorded.
+func (r runInfo) properties() map[string]interface{} {
+	props := make(map[string]interface{})
+	if r.fsid != "" {
+		props["fsid"] = r.fsid
+	}
+	if r.note != "" {
+		props["note"] = r.note
+	}
+	return props
+}
+
+// latestRun returns the id of the run started last.
+func (mc *MemgraphClient) latestRun(ctx context.Context) (string, error) {
+	_, rows, err := mc.Query(ctx, "MATCH (r:Run) RETURN r.id AS id ORDER BY r.started_at DESC LIMIT 1", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the latest run: %v", err)
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("no runs have been imported")
+	}
+	return fmt.Sprint(rows[0][0]), nil
+}
+
+// runExists reports whether a Run node with id exists.
+func (mc *MemgraphClient) runExists(ctx context.Context, id string) (bool, error) {
+	_, rows, err := mc.Query(ctx, "MATCH (r:Run {id: $run}) RETURN r.id", map[string]interface{}{"run": id})
+	if err != nil {
+		return false, fmt.Errorf("failed to look up run %s: %v", id, err)
+	}
+	return len(rows) > 0, nil
+}
+
+// runOSDs returns how many OSDs a run imported.
+func (mc *MemgraphClient) runOSDs(ctx context.Context, id string) (int, error) {
+	_, rows, err := mc.Query(ctx, "MATCH (:Run {id: $run})-[:IMPORTED]->(o:OSD) RETURN count(o)", map[string]interface{}{"run": id})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the OSDs of run %s: %v", id, err)
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	n, _ := rows[0][0].(int64)
+	return int(n), nil
+}
+
+var listRuns = analysis{
+	name:        "runs",
+	description: "Imported runs",
+	query: `
+		MATCH (r:Run)
+		OPTIONAL MATCH (r)-[:IMPORTED]->(o:OSD)
+		WITH r, collect(o.id) AS osds
+		RETURN r.id AS id, r.started_at AS started_at, r.finished_at AS finished_at, r.fsid AS fsid, osds, r.note AS note
+		ORDER BY started_at
+		LIMIT $limit
+	`,
+}
+
+// deleteBatchSize is how many relationships runs delete removes per
+// transaction, so that deleting a large run does not exhaust Memgraph's
+// memory.
+const deleteBatchSize = 10000
+
+type runsConfig struct {
+	memgraphConfig
+
+	action string
+	args   []string
+	output string
+	limit  int
+	yes    bool
+}
+
+func parseRunsConfig(args []string, output io.Writer) (*runsConfig, error) {
+	cfg := &runsConfig{}
+	fs := flag.NewFlagSet("ceph-topology-to-memgraph runs", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(output, "Usage: go run ./ceph-topology-to-memgraph runs list [flags]")
+		_, _ = fmt.Fprintln(output, "       go run ./ceph-topology-to-memgraph runs diff [flags] <from run> <to run>")
+		_, _ = fmt.Fprintln(output, "       go run ./ceph-topology-to-memgraph runs delete -yes [flags] <run>")
+		_, _ = fmt.Fprintln(output, "Lists imported runs, compares two of them (the same as diff), or deletes")
+		_, _ = fmt.Fprintln(output, "a run's relationships and the nodes no other run uses.")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&cfg.output, "output", "table", "Output format: "+strings.Join(analysisFormats, ", "))
+	fs.IntVar(&cfg.limit, "limit", defaultReportLimit, "Maximum rows per report")
+	fs.BoolVar(&cfg.yes, "yes", false, "Really delete the run")
+	cfg.memgraphConfig.addFlags(fs)
+
+	if len(args) == 0 {
+		fs.Usage()
+		return nil, fmt.Errorf("missing action: list, diff or delete")
+	}
+	if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		fs.Usage()
+		return nil, flag.ErrHelp
+	}
+	cfg.action = args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		return nil, err
+	}
+	cfg.args = fs.Args()
+
+	want := map[string]int{"list": 0, "delete": 1}
+	n, ok := want[cfg.action]
+	if !ok {
+		return nil, fmt.Errorf("unknown action %q: want list, diff or delete", cfg.action)
+	}
+	if len(cfg.args) != n {
+		return nil, fmt.Errorf("runs %s takes %d run IDs, got %d", cfg.action, n, len(cfg.args))
+	}
+
+	switch cfg.output {
+	case "table", "json", "csv":
+	default:
+		return nil, fmt.Errorf("unknown output format %q (want one of %s)", cfg.output, strings.Join(analysisFormats, ", "))
+	}
+	if cfg.limit < 1 {
+		return nil, fmt.Errorf("-limit must be at least 1")
+	}
+
+	if err := cfg.memgraphConfig.resolve(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func runRuns(args []string) int {
+	// runs diff stays as an alias of diff, which compares runs the same way
+	if len(args) > 0 && args[0] == "diff" {
+		return runDiff(args[1:])
+	}
+
+	cfg, err := parseRunsConfig(args, os.Stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	ctx := context.Background()
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	client, err := cfg.memgraphConfig.connect(ctx, logger)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error connecting to Memgraph: %v\n", err)
+		return 1
+	}
+	defer client.Close(ctx)
+
+	for _, id := range cfg.args {
+		exists, err := client.runExists(ctx, id)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if !exists {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: no run %s\n", id)
+			return 1
+		}
+	}
+
+	var reports []Report
+	switch cfg.action {
+	case "list":
+		reports, err = client.runReports(ctx, []analysis{listRuns}, nil, cfg.limit)
+	case "delete":
+		err = deleteRun(ctx, client, logger, cfg.args[0], cfg.yes)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if err := writeReports(os.Stdout, cfg.output, reports); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error writing reports: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runReports runs analyses with params, cutting each to limit rows.
+func (mc *MemgraphClient) runReports(ctx context.Context, analyses []analysis, params map[string]interface{}, limit int) ([]Report, error) {
+	var reports []Report
+	for _, a := range analyses {
+		// Ask for one row more than the limit to tell whether it cut anything
+		p := map[string]interface{}{"limit": limit + 1}
+		for k, v := range params {
+			p[k] = v
+		}
+		columns, rows, err := mc.Query(ctx, a.query, p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run report %s: %v", a.name, err)
+		}
+		reports = append(reports, newReport(a, columns, rows, limit))
+	}
+	return reports, nil
+}
+
+// runStore is what deleting a run needs of the database, which
+// MemgraphClient implements.
+type runStore interface {
+	Query(ctx context.Context, query string, params map[string]interface{}) ([]string, [][]interface{}, error)
+	write(ctx context.Context, st statement) ([]*neo4j.Record, neo4j.ResultSummary, error)
+}
+
+// runNodes finds the nodes a run's relationships touch, by label, with the
+// query returning the ids of each.
+var runNodes = []struct {
+	label string
+	query string
+}{
+	{"OSD", "MATCH (:Run {id: $run})-[:IMPORTED]->(n:OSD) RETURN n.id"},
+	{"PG", "MATCH (:OSD)-[:CONTAINS {run: $run}]->(n:PG) RETURN DISTINCT n.id"},
+	{"Object", "MATCH (:PG)-[:CONTAINS {run: $run}]->(n:Object) RETURN DISTINCT n.id"},
+	{"UniqueObject", "MATCH (:OSD)-[:CONTAINS {run: $run}]->(n:UniqueObject) RETURN DISTINCT n.id"},
+}
+
+// deleteRun deletes the run's relationships in batches, then its node and
+// IMPORTED relationships, then those of the OSD, PG and object nodes it
+// touched that no other run holds on to. Without yes it only says how much it
+// would delete. Running it again after an interruption finishes deleting the
+// relationships, but nodes the first attempt had already cut loose are no
+// longer tied to the run and stay.
+func deleteRun(ctx context.Context, db runStore, logger *log.Logger, id string, yes bool) error {
+	params := map[string]interface{}{"run": id}
+	if !yes {
+		_, rows, err := db.Query(ctx, "MATCH ()-[c:CONTAINS|IS {run: $run}]->() RETURN count(c) AS count", params)
+		if err != nil {
+			return fmt.Errorf("failed to count the run's relationships: %v", err)
+		}
+		return fmt.Errorf("run %s has %v relationships; pass -yes to delete them", id, rows[0][0])
+	}
+
+	// The nodes have to be found before the relationships tying them to
+	// the run are gone
+	touched := make(map[string][]interface{})
+	for _, n := range runNodes {
+		_, rows, err := db.Query(ctx, n.query, params)
+		if err != nil {
+			return fmt.Errorf("failed to find the run's %s nodes: %v", n.label, err)
+		}
+		for _, row := range rows {
+			touched[n.label] = append(touched[n.label], row[0])
+		}
+	}
+
+	deleted, err := deleteInBatches(ctx, db, statement{
+		query: `
+			MATCH ()-[c:CONTAINS|IS {run: $run}]->()
+			WITH c LIMIT $batch
+			DELETE c
+			RETURN count(c) AS deleted
+		`,
+		params: map[string]interface{}{"run": id, "batch": deleteBatchSize},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete the run's relationships: %v", err)
+	}
+	logger.Printf("Deleted %d relationships of run %s", deleted, id)
+
+	if _, _, err := db.write(ctx, statement{
+		query:  "MATCH (r:Run {id: $run}) DETACH DELETE r",
+		params: params,
+	}); err != nil {
+		return fmt.Errorf("failed to delete the run's node: %v", err)
+	}
+
+	var orphans int64
+	for _, n := range runNodes {
+		ids := touched[n.label]
+		for start := 0; start < len(ids); start += deleteBatchSize {
+			batch := ids[start:min(start+deleteBatchSize, len(ids))]
+			records, _, err := db.write(ctx, statement{
+				// The label is one of runNodes', not user input
+				query: fmt.Sprintf(`
+					UNWIND $ids AS id
+					MATCH (n:%s {id: id})
+					WHERE NOT (n)--()
+					DELETE n
+					RETURN count(n) AS deleted
+				`, n.label),
+				params: map[string]interface{}{"ids": batch},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to delete %s nodes left without relationships: %v", n.label, err)
+			}
+			if len(records) > 0 {
+				v, _ := records[0].Get("deleted")
+				count, _ := v.(int64)
+				orphans += count
+			}
+		}
+	}
+	logger.Printf("Deleted run %s and %d nodes no other run uses", id, orphans)
+	return nil
+}
+
+// deleteInBatches runs st, which deletes up to $batch items and returns how
+// many, until it deletes none, and returns the total.
+func deleteInBatches(ctx context.Context, db runStore, st statement) (int64, error) {
+	var total int64
+	for {
+		records, _, err := db.write(ctx, st)
+		if err != nil {
+			return total, err
+		}
+		var n int64
+		if len(records) > 0 {
+			v, _ := records[0].Get("deleted")
+			n, _ = v.(int64)
+		}
+		if n == 0 {
+			return total, nil
+		}
+		total += n
+	}
+}
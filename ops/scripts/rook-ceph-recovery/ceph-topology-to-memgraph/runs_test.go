@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// fakeRunStore holds one run for deleteRun: its relationships, the ids of
+// the nodes they touch by label, and which of those another run still uses.
+type fakeRunStore struct {
+	relationships int64
+	nodes         map[string][]interface{}
+	used          map[interface{}]bool
+	failLabel     string
+
+	writes   []string
+	orphaned map[string][][]interface{} // batches of ids checked, by label
+}
+
+func (s *fakeRunStore) Query(ctx context.Context, query string, params map[string]interface{}) ([]string, [][]interface{}, error) {
+	if strings.Contains(query, "count(c)") {
+		return []string{"count"}, [][]interface{}{{s.relationships}}, nil
+	}
+	for _, n := range runNodes {
+		if query != n.query {
+			continue
+		}
+		if n.label == s.failLabel {
+			return nil, nil, errors.New("connection reset")
+		}
+		// Nodes are only found through the run's relationships
+		if len(s.writes) > 0 {
+			return []string{"n.id"}, nil, nil
+		}
+		var rows [][]interface{}
+		for _, id := range s.nodes[n.label] {
+			rows = append(rows, []interface{}{id})
+		}
+		return []string{"n.id"}, rows, nil
+	}
+	return nil, nil, fmt.Errorf("unexpected query %q", query)
+}
+
+func (s *fakeRunStore) write(ctx context.Context, st statement) ([]*neo4j.Record, neo4j.ResultSummary, error) {
+	query := strings.Join(strings.Fields(st.query), " ")
+	s.writes = append(s.writes, query)
+	switch {
+	case strings.Contains(query, "DELETE c"):
+		n := min(s.relationships, int64(st.params["batch"].(int)))
+		s.relationships -= n
+		return deletedRecord(n), nil, nil
+	case strings.Contains(query, "DETACH DELETE r"):
+		return nil, nil, nil
+	case strings.HasPrefix(query, "UNWIND $ids"):
+		label := strings.TrimPrefix(query, "UNWIND $ids AS id MATCH (n:")
+		label = label[:strings.Index(label, " ")]
+		ids := st.params["ids"].([]interface{})
+		if s.orphaned == nil {
+			s.orphaned = make(map[string][][]interface{})
+		}
+		s.orphaned[label] = append(s.orphaned[label], ids)
+		var n int64
+		for _, id := range ids {
+			if !s.used[id] {
+				n++
+			}
+		}
+		return deletedRecord(n), nil, nil
+	}
+	return nil, nil, fmt.Errorf("unexpected statement %q", query)
+}
+
+func deletedRecord(n int64) []*neo4j.Record {
+	return []*neo4j.Record{{Keys: []string{"deleted"}, Values: []interface{}{n}}}
+}
+
+func TestDeleteRunWithoutYes(t *testing.T) {
+	s := &fakeRunStore{relationships: 42}
+	err := deleteRun(context.Background(), s, testLogger(), "abc", false)
+	if err == nil || !strings.Contains(err.Error(), "has 42 relationships") {
+		t.Fatalf("deleteRun() = %v, want the count of relationships", err)
+	}
+	if len(s.writes) != 0 {
+		t.Errorf("wrote %q without -yes", s.writes)
+	}
+}
+
+func TestDeleteRun(t *testing.T) {
+	var unique []interface{}
+	for i := 0; i <= deleteBatchSize; i++ {
+		unique = append(unique, fmt.Sprintf("1:o%d@1", i))
+	}
+	s := &fakeRunStore{
+		relationships: 2*deleteBatchSize + 5,
+		nodes: map[string][]interface{}{
+			"OSD":          {"1", "2"},
+			"PG":           {"1.0"},
+			"Object":       {"1:a", "1:b"},
+			"UniqueObject": unique,
+		},
+		// Held by another run
+		used: map[interface{}]bool{"2": true, "1:a": true},
+	}
+	var logs bytes.Buffer
+	if err := deleteRun(context.Background(), s, log.New(&logs, "", 0), "abc", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.relationships != 0 {
+		t.Errorf("%d relationships left", s.relationships)
+	}
+	// Three full or partial batches, then one that finds nothing left
+	for i, want := range []string{"DELETE c", "DELETE c", "DELETE c", "DELETE c", "DETACH DELETE r"} {
+		if !strings.Contains(s.writes[i], want) {
+			t.Errorf("write %d = %q, want %s", i, s.writes[i], want)
+		}
+	}
+
+	for label, ids := range s.nodes {
+		var checked []interface{}
+		for _, batch := range s.orphaned[label] {
+			if len(batch) > deleteBatchSize {
+				t.Errorf("%s batch of %d ids, want at most %d", label, len(batch), deleteBatchSize)
+			}
+			checked = append(checked, batch...)
+		}
+		if !reflect.DeepEqual(checked, ids) {
+			t.Errorf("%s ids checked = %v, want %v", label, checked, ids)
+		}
+	}
+	if got := len(s.orphaned["UniqueObject"]); got != 2 {
+		t.Errorf("UniqueObject ids checked in %d batches, want 2", got)
+	}
+
+	want := fmt.Sprintf("Deleted run abc and %d nodes no other run uses", deleteBatchSize+4)
+	if !strings.Contains(logs.String(), want) {
+		t.Errorf("log = %q, want %q", logs.String(), want)
+	}
+}
+
+func TestDeleteRunNodeLookupFails(t *testing.T) {
+	s := &fakeRunStore{relationships: 3, failLabel: "PG"}
+	err := deleteRun(context.Background(), s, testLogger(), "abc", true)
+	if err == nil || !strings.Contains(err.Error(), "PG nodes") {
+		t.Fatalf("deleteRun() = %v, want the failed PG lookup", err)
+	}
+	if len(s.writes) != 0 {
+		t.Errorf("wrote %q after failing to find the run's nodes", s.writes)
+	}
+}
+
+func TestRunNodesCoverEveryLabel(t *testing.T) {
+	found := make(map[string]bool)
+	for _, n := range runNodes {
+		found[n.label] = true
+	}
+	for ref := range testGraph(t).nodes {
+		if ref.label != "Run" && !found[ref.label] {
+			t.Errorf("runNodes does not find the run's %s nodes", ref.label)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// schemaItem is one index or constraint on a single property of a label.
+type schemaItem struct {
+	kind     string // "index" or "unique"
+	label    string
+	property string
+}
+
+func (s schemaItem) String() string {
+	if s.kind == "unique" {
+		return fmt.Sprintf("unique constraint on :%s(%s)", s.label, s.property)
+	}
+	return fmt.Sprintf("index on :%s(%s)", s.label, s.property)
+}
+
+// create is the Cypher creating s, in Neo4j's syntax or Memgraph's. Both only
+// accept it outside explicit transactions.
+func (s schemaItem) create(neo4j bool) string {
+	switch {
+	case neo4j && s.kind == "unique":
+		return fmt.Sprintf("CREATE CONSTRAINT IF NOT EXISTS FOR (n:%s) REQUIRE n.%s IS UNIQUE", s.label, s.property)
+	case neo4j:
+		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS FOR (n:%s) ON (n.%s)", s.label, s.property)
+	case s.kind == "unique":
+		return fmt.Sprintf("CREATE CONSTRAINT ON (n:%s) ASSERT n.%s IS UNIQUE", s.label, s.property)
+	}
+	return fmt.Sprintf("CREATE INDEX ON :%s(%s)", s.label, s.property)
+}
+
+// schemaLabels are the labels the importer MERGEs on id.
+var schemaLabels = []string{"Run", "OSD", "PG", "Object", "UniqueObject"}
+
+// expectedSchema is what the importer needs on each of schemaLabels. A
+// uniqueness constraint does not make Memgraph index the property, so there
+// each label gets both; Neo4j backs the constraint with an index of its own
+// and refuses a second one.
+func expectedSchema(neo4j bool) []schemaItem {
+	var items []schemaItem
+	for _, label := range schemaLabels {
+		if !neo4j {
+			items = append(items, schemaItem{kind: "index", label: label, property: "id"})
+		}
+		items = append(items, schemaItem{kind: "unique", label: label, property: "id"})
+	}
+	return items
+}
+
+// Schema reads the label-property indexes and single-property uniqueness
+// constraints that exist. Other kinds of index and constraint are left out.
+func (mc *MemgraphClient) Schema(ctx context.Context) (map[schemaItem]bool, error) {
+	if mc.neo4j {
+		return mc.neo4jSchema(ctx)
+	}
+	existing := make(map[schemaItem]bool)
+
+	keys, rows, err := mc.Query(ctx, "SHOW INDEX INFO", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexes: %v", err)
+	}
+	for _, row := range rows {
+		label, props := schemaColumn(keys, row, "label"), schemaColumn(keys, row, "property")
+		if len(props) == 1 && len(label) == 1 {
+			existing[schemaItem{kind: "index", label: label[0], property: props[0]}] = true
+		}
+	}
+
+	keys, rows, err = mc.Query(ctx, "SHOW CONSTRAINT INFO", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list constraints: %v", err)
+	}
+	for _, row := range rows {
+		kind := schemaColumn(keys, row, "constraint type")
+		label, props := schemaColumn(keys, row, "label"), schemaColumn(keys, row, "properties")
+		if len(kind) == 1 && kind[0] == "unique" && len(props) == 1 && len(label) == 1 {
+			existing[schemaItem{kind: "unique", label: label[0], property: props[0]}] = true
+		}
+	}
+	return existing, nil
+}
+
+// neo4jSchema is Schema on Neo4j, which leaves out the indexes backing
+// constraints.
+func (mc *MemgraphClient) neo4jSchema(ctx context.Context) (map[schemaItem]bool, error) {
+	existing := make(map[schemaItem]bool)
+
+	keys, rows, err := mc.Query(ctx, "SHOW INDEXES YIELD type, entityType, labelsOrTypes, properties, owningConstraint", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexes: %v", err)
+	}
+	for _, row := range rows {
+		owned := schemaColumn(keys, row, "owningConstraint")
+		entity := schemaColumn(keys, row, "entityType")
+		label, props := schemaColumn(keys, row, "labelsOrTypes"), schemaColumn(keys, row, "properties")
+		if len(owned) == 0 && len(entity) == 1 && entity[0] == "NODE" && len(props) == 1 && len(label) == 1 {
+			existing[schemaItem{kind: "index", label: label[0], property: props[0]}] = true
+		}
+	}
+
+	keys, rows, err = mc.Query(ctx, "SHOW CONSTRAINTS YIELD type, labelsOrTypes, properties", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list constraints: %v", err)
+	}
+	for _, row := range rows {
+		kind := schemaColumn(keys, row, "type")
+		label, props := schemaColumn(keys, row, "labelsOrTypes"), schemaColumn(keys, row, "properties")
+		// UNIQUENESS before Neo4j 5.7, NODE_PROPERTY_UNIQUENESS since
+		unique := len(kind) == 1 && (kind[0] == "UNIQUENESS" || kind[0] == "NODE_PROPERTY_UNIQUENESS")
+		if unique && len(props) == 1 && len(label) == 1 {
+			existing[schemaItem{kind: "unique", label: label[0], property: props[0]}] = true
+		}
+	}
+	return existing, nil
+}
+
+// schemaColumn returns a column of a SHOW ... INFO row as strings. Depending
+// on the Memgraph version, properties are either a string or a list.
+func schemaColumn(keys []string, row []interface{}, name string) []string {
+	for i, key := range keys {
+		if key != name || i >= len(row) {
+			continue
+		}
+		switch v := row[i].(type) {
+		case string:
+			return []string{v}
+		case []interface{}:
+			var values []string
+			for _, e := range v {
+				values = append(values, fmt.Sprint(e))
+			}
+			return values
+		}
+	}
+	return nil
+}
+
+// EnsureSchema creates whichever of the indexes and uniqueness constraints the
+// importer's MERGEs rely on are missing, and reports drift from what it
+// expects: items that could not be created fail the import, while unexpected
+// items on the importer's labels are only reported.
+func (mc *MemgraphClient) EnsureSchema(ctx context.Context) error {
+	mc.logger.Println("Checking schema...")
+	fmt.Println("Checking schema...")
+
+	existing, err := mc.Schema(ctx)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	createFailed := make(map[schemaItem]bool)
+	for _, item := range expectedSchema(mc.neo4j) {
+		if existing[item] {
+			continue
+		}
+		mc.logger.Printf("Creating %s", item)
+		fmt.Printf("Creating %s\n", item)
+		if _, _, err := mc.Query(ctx, item.create(mc.neo4j), nil); err != nil {
+			// Most likely duplicate ids left by imports that ran without the
+			// constraint
+			mc.logger.Printf("Failed to create %s: %v", item, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", item, err))
+			createFailed[item] = true
+		}
+	}
+
+	existing, err = mc.Schema(ctx)
+	if err != nil {
+		return err
+	}
+	expected := make(map[schemaItem]bool)
+	for _, item := range expectedSchema(mc.neo4j) {
+		expected[item] = true
+		if !existing[item] && !createFailed[item] {
+			failed = append(failed, fmt.Sprintf("%s: missing after creating it", item))
+		}
+	}
+
+	labels := make(map[string]bool)
+	for _, label := range schemaLabels {
+		labels[label] = true
+	}
+	var extra []string
+	for item := range existing {
+		if labels[item.label] && !expected[item] {
+			extra = append(extra, item.String())
+		}
+	}
+	sort.Strings(extra)
+	for _, item := range extra {
+		mc.logger.Printf("Schema drift: unexpected %s", item)
+		fmt.Printf("Schema drift: unexpected %s\n", item)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("schema is missing %s", strings.Join(failed, "; "))
+	}
+	mc.logger.Println("Schema OK")
+	fmt.Println("Schema OK")
+	return nil
+}
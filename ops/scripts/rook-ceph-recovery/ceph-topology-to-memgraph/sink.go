@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// sinks are the values -sink accepts.
+var sinks = []string{"memgraph", "neo4j", "cypherl", "graphml", "csv", "memory"}
+
+// graphSink is where an import writes the topology. Its writes are applied
+// through writers, one per goroutine of the write pipeline.
+type graphSink interface {
+	// Prepare checks the sink can be written to, before any OSD is listed.
+	Prepare(ctx context.Context) error
+	newWriter(ctx context.Context) graphWriter
+	// Finish reports on and persists what was written, once every write
+	// has been applied.
+	Finish(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// graphWriter applies the writes of an import. Writes for an OSD's node come
+// before those for its PGs, and a PG's node before its objects.
+type graphWriter interface {
+	CreateOSDNode(ctx context.Context, osdID string, props map[string]interface{}) error
+	MergePG(ctx context.Context, pgID, osdID string, props map[string]interface{}) error
+	SetCopyProperties(ctx context.Context, pgID, osdID string, props map[string]interface{}) error
+	WriteObjects(ctx context.Context, objects []listedObject, pgID, osdID string) error
+	SetObjectDetails(ctx context.Context, objects []listedObject, osdID string) error
+	Close(ctx context.Context) error
+}
+
+// sinkOutput is where cfg's sink writes: the server's URI, or the file (or,
+// for csv, directory) written, by default in the run's directory.
+func sinkOutput(cfg *config, dir string) string {
+	switch cfg.sink {
+	case "memgraph", "neo4j":
+		return cfg.memgraphURI
+	case "memory":
+		return "memory"
+	case "csv":
+		if cfg.sinkOutput == "" {
+			return dir
+		}
+	default:
+		if cfg.sinkOutput == "" {
+			return filepath.Join(dir, "topology."+cfg.sink)
+		}
+	}
+	return cfg.sinkOutput
+}
+
+// openSink opens cfg's sink for run. Exports and reports built in memory are
+// only written at the end, so there is nothing for -resume to continue.
+func openSink(ctx context.Context, cfg *config, run runInfo, dir string, logger *log.Logger) (graphSink, error) {
+	output := sinkOutput(cfg, dir)
+	switch cfg.sink {
+	case "memgraph", "neo4j":
+		fmt.Printf("Connecting to %s at %s\n", serverName(cfg.sink), output)
+		client, err := cfg.memgraphConfig.connect(ctx, logger, func(config *neo4j.Config) {
+			config.MaxTransactionRetryTime = cfg.retryTime
+		})
+		if err != nil {
+			return nil, err
+		}
+		client.neo4j = cfg.sink == "neo4j"
+		client.run = run
+		return client, nil
+	case "cypherl":
+		return newCypherFileSink(output, run, cfg.resume != "", logger)
+	case "graphml", "csv":
+		if cfg.resume != "" {
+			return nil, fmt.Errorf("a %s export is written in one go at the end of a run and cannot be resumed", cfg.sink)
+		}
+		return &graphExportSink{format: cfg.sink, path: output, run: run, graph: newGraph(), logger: logger}, nil
+	case "memory":
+		if cfg.resume != "" {
+			return nil, fmt.Errorf("reports over a topology in memory cannot be resumed")
+		}
+		return &topologySink{topology: newTopology(), limit: defaultReportLimit}, nil
+	}
+	return nil, fmt.Errorf("unknown sink %q", cfg.sink)
+}
+
+func serverName(sink string) string {
+	if sink == "neo4j" {
+		return "Neo4j"
+	}
+	return "Memgraph"
+}
+
+// Prepare tests the connection, checks Memgraph takes writes, sets up the
+// schema, since MERGEs without an index on id scan the whole label, and
+// merges the run's node.
+func (mc *MemgraphClient) Prepare(ctx context.Context) error {
+	if err := mc.TestConnection(ctx); err != nil {
+		return err
+	}
+	if !mc.neo4j {
+		if err := mc.checkWritable(ctx); err != nil {
+			return err
+		}
+	}
+	if err := mc.EnsureSchema(ctx); err != nil {
+		return err
+	}
+	if _, _, err := mc.write(ctx, runStatement(mc.run)); err != nil {
+		return fmt.Errorf("failed to create run node: %v", err)
+	}
+	return nil
+}
+
+// Finish marks the run finished, prints statistics and, on Memgraph,
+// snapshots the database.
+func (mc *MemgraphClient) Finish(ctx context.Context) error {
+	if _, _, err := mc.write(ctx, finishRunStatement(mc.run.id)); err != nil {
+		return fmt.Errorf("failed to finish run: %v", err)
+	}
+	if err := mc.GetStats(ctx); err != nil {
+		return err
+	}
+	if mc.neo4j {
+		return nil
+	}
+	return mc.CreateSnapshot(ctx)
+}
+
+// cypherFileSink writes the import's statements to a .cypherl file, one per
+// line, to be replayed later with mgconsole or cypher-shell. The schema comes
+// first, in Memgraph's syntax.
+type cypherFileSink struct {
+	path   string
+	run    runInfo
+	logger *log.Logger
+
+	mu         sync.Mutex
+	file       *os.File
+	w          *bufio.Writer
+	statements int
+}
+
+// newCypherFileSink creates path, or appends to it when resuming a run.
+func newCypherFileSink(path string, run runInfo, resume bool, logger *log.Logger) (*cypherFileSink, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cypher file: %v", err)
+	}
+	logger.Printf("Writing Cypher statements to %s", path)
+	fmt.Printf("Writing Cypher statements to %s\n", path)
+	return &cypherFileSink{path: path, run: run, logger: logger, file: file, w: bufio.NewWriter(file)}, nil
+}
+
+func (s *cypherFileSink) Prepare(ctx context.Context) error {
+	for _, item := range expectedSchema(false) {
+		if err := s.write(statement{query: item.create(false)}); err != nil {
+			return err
+		}
+	}
+	return s.write(runStatement(s.run))
+}
+
+func (s *cypherFileSink) newWriter(ctx context.Context) graphWriter {
+	return cypherFileWriter{s}
+}
+
+// write appends st and flushes it, so the checkpoint never runs ahead of the
+// file.
+func (s *cypherFileSink) write(st statement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.WriteString(st.render() + "\n"); err != nil {
+		return fmt.Errorf("failed to write Cypher file: %v", err)
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write Cypher file: %v", err)
+	}
+	s.statements++
+	return nil
+}
+
+func (s *cypherFileSink) Finish(ctx context.Context) error {
+	if err := s.write(finishRunStatement(s.run.id)); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger.Printf("Wrote %d Cypher statements to %s", s.statements, s.path)
+	fmt.Printf("Wrote %d Cypher statements to %s\n", s.statements, s.path)
+	fmt.Printf("Replay with: mgconsole < %s\n", s.path)
+	return nil
+}
+
+func (s *cypherFileSink) Close(ctx context.Context) error {
+	return s.file.Close()
+}
+
+type cypherFileWriter struct {
+	s *cypherFileSink
+}
+
+func (w cypherFileWriter) CreateOSDNode(ctx context.Context, osdID string, props map[string]interface{}) error {
+	return w.s.write(osdStatement(w.s.run.id, osdID, props))
+}
+
+func (w cypherFileWriter) MergePG(ctx context.Context, pgID, osdID string, props map[string]interface{}) error {
+	return w.s.write(pgStatement(w.s.run.id, pgID, osdID, props))
+}
+
+func (w cypherFileWriter) SetCopyProperties(ctx context.Context, pgID, osdID string, props map[string]interface{}) error {
+	return w.s.write(copyStatement(w.s.run.id, pgID, osdID, props))
+}
+
+func (w cypherFileWriter) WriteObjects(ctx context.Context, objects []listedObject, pgID, osdID string) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	return w.s.write(objectsStatement(w.s.run.id, objects, pgID, osdID))
+}
+
+func (w cypherFileWriter) SetObjectDetails(ctx context.Context, objects []listedObject, osdID string) error {
+	return w.s.write(objectDetailsStatement(w.s.run.id, objects, osdID))
+}
+
+func (w cypherFileWriter) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"main/internal/ceph"
+	"main/internal/executor"
+	"main/internal/objectstore"
+)
+
+const (
+	// progressEvery is how often, in objects, listing progress is printed.
+	progressEvery = 100000
+	// maxLineSize bounds one line of --op list output; object names are at
+	// most a few KiB.
+	maxLineSize = 1 << 20
+)
+
+// osdImport is the outcome of importing one OSD, for the final summary.
+type osdImport struct {
+	target      osdTarget
+	pgsFilepath string
+	pgs         int
+	objects     int
+	duration    time.Duration
+	err         error // listing failed
+
+	// pending counts the OSD's writes not yet applied
+	pending sync.WaitGroup
+	queued  int
+
+	mu          sync.Mutex
+	writeErr    error // a write failed; writes queued after it are dropped
+	writeErrSeq int
+}
+
+// setWriteErr records that the seq'th write queued for the OSD failed. Writers
+// run concurrently, so the error kept is that of the earliest queued write to
+// fail rather than of the first to be noticed.
+func (r *osdImport) setWriteErr(seq int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.writeErr == nil || seq < r.writeErrSeq {
+		r.writeErr, r.writeErrSeq = err, seq
+	}
+}
+
+// dropped reports whether the seq'th write should be skipped, as the listing
+// or an earlier write failed.
+func (r *osdImport) dropped(seq int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err != nil || (r.writeErr != nil && r.writeErrSeq < seq)
+}
+
+func (r *osdImport) failure() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	return r.writeErr
+}
+
+// writeFunc is one write to the sink, queued while a listing is streamed.
+type writeFunc func(ctx context.Context, w graphWriter) error
+
+type writeOp struct {
+	osd   *osdImport
+	seq   int
+	write writeFunc
+}
+
+// writePipeline spreads writes over concurrent writers, each with a session of
+// its own on Bolt sinks. Writes queued under the same key always go to the same writer, so
+// they are applied in the order they were queued; writes under different keys
+// may be applied in any order. Each writer's queue is bounded, so a listing
+// never runs more than a few batches ahead of the sink.
+type writePipeline struct {
+	queues []chan writeOp
+	wg     sync.WaitGroup
+}
+
+// newWritePipeline starts writers over sink. With sink nil (a dry run) writes
+// are dropped.
+func newWritePipeline(ctx context.Context, sink graphSink, writers, depth int, logger *log.Logger) *writePipeline {
+	p := &writePipeline{}
+	for i := 0; i < writers; i++ {
+		queue := make(chan writeOp, depth)
+		p.queues = append(p.queues, queue)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			var writer graphWriter
+			if sink != nil {
+				writer = sink.newWriter(ctx)
+				defer writer.Close(ctx)
+			}
+			for op := range queue {
+				if writer != nil && !op.osd.dropped(op.seq) {
+					if err := op.write(ctx, writer); err != nil {
+						logger.Printf("OSD %s: write failed: %v", op.osd.target.id, err)
+						op.osd.setWriteErr(op.seq, err)
+					}
+				}
+				op.osd.pending.Done()
+			}
+		}()
+	}
+	return p
+}
+
+// queue queues write for r under key. Only r's streamOSD queues its writes.
+func (p *writePipeline) queue(r *osdImport, key string, write writeFunc) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	r.queued++
+	r.pending.Add(1)
+	p.queues[h.Sum32()%uint32(len(p.queues))] <- writeOp{osd: r, seq: r.queued, write: write}
+}
+
+// close waits for every queued write to be applied.
+func (p *writePipeline) close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// streamTargets streams the listing of every target, at most cfg.concurrency
+// at a time, and feeds the writes to cfg.writers writers. With sink nil (a dry
+// run) listings are only parsed and counted.
+func streamTargets(ctx context.Context, cfg *config, ex executor.Executor, sink graphSink, targets []osdTarget, meta *clusterMetadata, cp *checkpoint, dir string, cached bool, logger *log.Logger) ([]*osdImport, bool) {
+	pipeline := newWritePipeline(ctx, sink, cfg.writers, 4*cfg.concurrency, logger)
+
+	imports := make([]*osdImport, len(targets))
+	slots := make(chan struct{}, cfg.concurrency)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		r := &osdImport{
+			target:      target,
+			pgsFilepath: filepath.Join(dir, fmt.Sprintf("osd-%s-pgs.json", target.id)),
+			err:         target.err,
+		}
+		imports[i] = r
+		if r.err != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			start := time.Now()
+			err := streamOSD(ctx, cfg, ex, r, meta, cp, pipeline, dir, cached, logger)
+			r.duration = time.Since(start)
+
+			// Writers may be checking dropped() concurrently
+			r.mu.Lock()
+			r.err = err
+			r.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	pipeline.close()
+
+	failed := false
+	for _, r := range imports {
+		if err := r.failure(); err != nil {
+			failed = true
+			logger.Printf("OSD %s failed: %v", r.target.id, err)
+		}
+	}
+	return imports, failed
+}
+
+// pgStream is the state of one PG while its OSD's listing is streamed.
+type pgStream struct {
+	seen int
+	// skip is how many of the PG's objects an earlier attempt at the run
+	// already committed.
+	skip int
+}
+
+// streamOSD streams one OSD's listing into pipeline. Writes for a PG are
+// queued under the PG's key, so its node is merged before its objects and it
+// is only marked done after them. The OSD's own view of each PG and the dumps
+// of selected objects are read once the listing has finished, since
+// ceph-objectstore-tool holds the store's lock while it lists.
+func streamOSD(ctx context.Context, cfg *config, ex executor.Executor, r *osdImport, meta *clusterMetadata, cp *checkpoint, pipeline *writePipeline, dir string, cached bool, logger *log.Logger) error {
+	osdID := r.target.id
+	osd, _ := strconv.Atoi(osdID)
+
+	listing, reused, err := openListing(ctx, ex, cfg.namespace, r.target.pod, osd, r.pgsFilepath, cached, logger)
+	if err != nil {
+		return fmt.Errorf("listing objects: %v", err)
+	}
+	defer listing.Close()
+
+	// PG writes match the OSD node, so it must exist before any is queued
+	props := meta.osdProperties(osdID)
+	pipeline.queue(r, osdID, func(ctx context.Context, w graphWriter) error {
+		return w.CreateOSDNode(ctx, osdID, props)
+	})
+	r.pending.Wait()
+
+	pgs := make(map[string]*pgStream)
+	var order []string
+	var selected []objectstore.Entry
+	var batch []listedObject
+	current := ""
+
+	// flush queues the batch of the current PG; --op list prints each PG's
+	// objects together, so a batch never spans PGs
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		objects, pgid := batch, current
+		pipeline.queue(r, pgKey(osdID, pgid), func(ctx context.Context, w graphWriter) error {
+			if err := w.WriteObjects(ctx, objects, pgid, osdID); err != nil {
+				return fmt.Errorf("failed to process batch for PG %s: %v", pgid, err)
+			}
+			return cp.commit(osdID, pgid, len(objects))
+		})
+		batch = nil
+	}
+
+	scanner := bufio.NewScanner(listing)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		entry, err := objectstore.ParseLine(line)
+		if err != nil {
+			logger.Printf("Warning: failed to parse line: %s, error: %v", line, err)
+			continue
+		}
+		if entry.Object.OID == "" {
+			continue // Skip empty OIDs
+		}
+
+		if entry.PGID != current {
+			flush()
+			current = entry.PGID
+			if pgs[current] == nil {
+				pgs[current] = startPG(r, current, meta, cp, pipeline, reused, logger)
+				order = append(order, current)
+			}
+		}
+
+		pg := pgs[current]
+		pg.seen++
+		r.objects++
+		if r.objects%progressEvery == 0 {
+			fmt.Printf("OSD %s: %d objects in %d PGs listed\n", osdID, r.objects, len(order))
+			if err := r.failure(); err != nil {
+				return err
+			}
+		}
+
+		if cfg.dumpObjects != nil && cfg.dumpObjects.MatchString(entry.Object.ID()) {
+			selected = append(selected, entry)
+		}
+		if pg.seen <= pg.skip {
+			continue
+		}
+		batch = append(batch, listedObject{Entry: entry})
+		if len(batch) == cfg.batchSize {
+			flush()
+		}
+	}
+	flush()
+	r.pgs = len(order)
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading object list: %v", err)
+	}
+	if err := listing.Close(); err != nil {
+		return fmt.Errorf("listing objects: %v", err)
+	}
+	fmt.Printf("OSD %s: listed %d objects in %d PGs\n", osdID, r.objects, r.pgs)
+	logger.Printf("OSD %s: listed %d objects in %d PGs", osdID, r.objects, r.pgs)
+
+	// Only now is every PG known to be complete
+	for _, pgid := range order {
+		pgid := pgid
+		pipeline.queue(r, pgKey(osdID, pgid), func(ctx context.Context, w graphWriter) error {
+			return cp.done(osdID, pgid)
+		})
+	}
+
+	if cfg.pgInfo {
+		for _, pgid := range order {
+			info := readPGInfo(ctx, ex, cfg.namespace, r.target.pod, osd, pgid, dir, logger)
+			if info == nil {
+				continue
+			}
+			pgid, props := pgid, copyProperties(info)
+			pipeline.queue(r, pgKey(osdID, pgid), func(ctx context.Context, w graphWriter) error {
+				return w.SetCopyProperties(ctx, pgid, osdID, props)
+			})
+		}
+	}
+
+	// Dumped objects span PGs, so their details wait for every PG's objects
+	var dumped []listedObject
+	flushDumped := func() {
+		if len(dumped) == 0 {
+			return
+		}
+		objects := dumped
+		pipeline.queue(r, osdID, func(ctx context.Context, w graphWriter) error {
+			return w.SetObjectDetails(ctx, objects, osdID)
+		})
+		dumped = nil
+	}
+	if len(selected) > 0 {
+		r.pending.Wait()
+	}
+	for _, entry := range selected {
+		dump := dumpObject(ctx, ex, cfg.namespace, r.target.pod, osd, entry, logger)
+		if dump == nil {
+			continue
+		}
+		dumped = append(dumped, listedObject{Entry: entry, Dump: dump})
+		if len(dumped) == cfg.batchSize {
+			flushDumped()
+		}
+	}
+	flushDumped()
+
+	return nil
+}
+
+// startPG queues the PG node of a PG seen for the first time in a listing,
+// unless an earlier attempt at the run already finished it. Committed objects
+// are counted in the order of the saved listing, so they are only skipped
+// when that listing is being reused; a PG listed afresh is written again
+// from its first object.
+func startPG(r *osdImport, pgid string, meta *clusterMetadata, cp *checkpoint, pipeline *writePipeline, reused bool, logger *log.Logger) *pgStream {
+	osdID := r.target.id
+	progress := cp.progress(osdID, pgid)
+	if progress.Done {
+		logger.Printf("Skipping PG %s on OSD %s, already imported", pgid, osdID)
+		return &pgStream{skip: math.MaxInt}
+	}
+	if progress.Objects > 0 && !reused {
+		logger.Printf("Importing PG %s on OSD %s again: its listing was not saved, so its %d committed objects cannot be skipped", pgid, osdID, progress.Objects)
+		if err := cp.restart(osdID, pgid); err != nil {
+			logger.Printf("Warning: failed to reset checkpoint of PG %s on OSD %s: %v", pgid, osdID, err)
+		}
+		progress.Objects = 0
+	}
+	if progress.Objects > 0 {
+		logger.Printf("Resuming PG %s on OSD %s after %d committed objects", pgid, osdID, progress.Objects)
+	}
+
+	props := meta.pgProperties(pgid)
+	pipeline.queue(r, pgKey(osdID, pgid), func(ctx context.Context, w graphWriter) error {
+		return w.MergePG(ctx, pgid, osdID, props)
+	})
+	return &pgStream{skip: progress.Objects}
+}
+
+// pgKey is the pipeline key of an OSD's writes for one of its PGs.
+func pgKey(osdID, pgid string) string {
+	return osdID + "/" + pgid
+}
+
+// openListing streams an OSD's --op list output, saving a copy to
+// pgsFilepath as it is read. With cached set, a listing saved by an earlier
+// attempt at the run is read instead of listing the OSD again, and reused is
+// returned true.
+func openListing(ctx context.Context, ex executor.Executor, ns, pod string, osd int, pgsFilepath string, cached bool, logger *log.Logger) (listing io.ReadCloser, reused bool, err error) {
+	if cached {
+		if f, err := os.Open(pgsFilepath); err == nil {
+			logger.Printf("Reusing object list %s", pgsFilepath)
+			return f, true, nil
+		}
+	}
+
+	cmd := ceph.ListCommand(ns, pod, osd, "")
+	stream, err := executor.Stream(ctx, ex, cmd[0], cmd[1:]...)
+	if err != nil {
+		return nil, false, err
+	}
+	file, err := os.Create(pgsFilepath + ".partial")
+	if err != nil {
+		_ = stream.Close()
+		return nil, false, fmt.Errorf("failed to write PGs file: %v", err)
+	}
+	return &teeListing{Reader: io.TeeReader(stream, file), stream: stream, file: file, path: pgsFilepath}, false, nil
+}
+
+// teeListing is a listing being saved as it is read. The copy only takes its
+// final name once the listing command has succeeded, so -resume never reuses
+// a truncated listing; a failed one is left as the .partial file. Close
+// reports the listing command's failure first, as that is the likelier cause.
+type teeListing struct {
+	io.Reader
+	stream io.Closer
+	file   *os.File
+	path   string
+	closed bool
+}
+
+func (t *teeListing) Close() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	streamErr := t.stream.Close()
+	fileErr := t.file.Close()
+	if streamErr != nil {
+		return streamErr
+	}
+	if fileErr != nil {
+		return fmt.Errorf("failed to write PGs file: %v", fileErr)
+	}
+	return os.Rename(t.file.Name(), t.path)
+}
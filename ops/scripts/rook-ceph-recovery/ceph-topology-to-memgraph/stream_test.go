@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"main/internal/ceph"
+	"main/internal/executor"
+)
+
+// recordingSink is a graphSink recording every write its writers apply.
+type recordingSink struct {
+	mu     sync.Mutex
+	writes []string
+	// fail maps a write to the error it fails with
+	fail map[string]error
+	// written, if set, is sent every write once it is recorded
+	written chan string
+}
+
+func (s *recordingSink) Prepare(ctx context.Context) error {
+	return nil
+}
+
+func (s *recordingSink) newWriter(ctx context.Context) graphWriter {
+	return recordingWriter{s}
+}
+
+func (s *recordingSink) Finish(ctx context.Context) error {
+	return nil
+}
+
+func (s *recordingSink) Close(ctx context.Context) error {
+	return nil
+}
+
+func (s *recordingSink) record(write string) error {
+	s.mu.Lock()
+	s.writes = append(s.writes, write)
+	err := s.fail[write]
+	s.mu.Unlock()
+	if s.written != nil {
+		s.written <- write
+	}
+	return err
+}
+
+func (s *recordingSink) recorded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.writes...)
+}
+
+type recordingWriter struct {
+	s *recordingSink
+}
+
+func (w recordingWriter) CreateOSDNode(ctx context.Context, osdID string, props map[string]interface{}) error {
+	return w.s.record("osd " + osdID)
+}
+
+func (w recordingWriter) MergePG(ctx context.Context, pgID, osdID string, props map[string]interface{}) error {
+	return w.s.record(fmt.Sprintf("pg %s %s", osdID, pgID))
+}
+
+func (w recordingWriter) SetCopyProperties(ctx context.Context, pgID, osdID string, props map[string]interface{}) error {
+	return w.s.record(fmt.Sprintf("copy %s %s", osdID, pgID))
+}
+
+func (w recordingWriter) WriteObjects(ctx context.Context, objects []listedObject, pgID, osdID string) error {
+	return w.s.record(fmt.Sprintf("objects %s %s %s", osdID, pgID, objectNames(objects)))
+}
+
+func (w recordingWriter) SetObjectDetails(ctx context.Context, objects []listedObject, osdID string) error {
+	return w.s.record(fmt.Sprintf("details %s %s", osdID, objectNames(objects)))
+}
+
+func (w recordingWriter) Close(ctx context.Context) error {
+	return nil
+}
+
+func objectNames(objects []listedObject) string {
+	names := make([]string, len(objects))
+	for i, obj := range objects {
+		names[i] = obj.Object.OID
+	}
+	return strings.Join(names, ",")
+}
+
+// listingLine is the --op list line of a head object of pool 1.
+func listingLine(pgid, oid string) string {
+	return fmt.Sprintf(`["%s",{"oid":"%s","key":"","snapid":-2,"hash":0,"max":0,"pool":1,"namespace":""}]`, pgid, oid)
+}
+
+func testConfig() *config {
+	return &config{
+		namespace:   "rook-ceph",
+		concurrency: 1,
+		writeConfig: writeConfig{batchSize: 2, writers: 1},
+	}
+}
+
+func testLogger() *log.Logger {
+	return log.New(io.Discard, "", 0)
+}
+
+// gatedFixture streams a fixture's output, holding back everything after its
+// first lines until release is closed, as a listing still running would.
+type gatedFixture struct {
+	*executor.Fixture
+	lines   int
+	release chan struct{}
+}
+
+func (g gatedFixture) Stream(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	stream, err := g.Fixture.Stream(ctx, name, args...)
+	if err != nil {
+		return nil, err
+	}
+	r, w := io.Pipe()
+	go func() {
+		defer stream.Close()
+		scanner := bufio.NewScanner(stream)
+		for n := 0; scanner.Scan(); n++ {
+			if n == g.lines {
+				<-g.release
+			}
+			if _, err := fmt.Fprintln(w, scanner.Text()); err != nil {
+				return
+			}
+		}
+		w.CloseWithError(scanner.Err())
+	}()
+	return r, nil
+}
+
+// failingStreamer streams output and then fails, as a listing command dying
+// partway through does.
+type failingStreamer struct {
+	output string
+	err    error
+}
+
+func (f failingStreamer) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return nil, f.err
+}
+
+func (f failingStreamer) Stream(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	return failingStream{strings.NewReader(f.output), f.err}, nil
+}
+
+type failingStream struct {
+	io.Reader
+	err error
+}
+
+func (s failingStream) Close() error {
+	return s.err
+}
+
+func TestStreamTargetsBatchesAsTheListingIsRead(t *testing.T) {
+	dir := t.TempDir()
+	listing := strings.Join([]string{
+		`["1.0",{"oid":"","key":"","snapid":-2,"hash":0,"max":0,"pool":1,"namespace":""}]`,
+		listingLine("1.0", "a"),
+		listingLine("1.0", "b"),
+		listingLine("1.0", "c"),
+		listingLine("1.1", "d"),
+		listingLine("1.1", "e"),
+	}, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "listing.json"), []byte(listing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture := executor.NewFixture(dir)
+	cmd := ceph.ListCommand("rook-ceph", "pod-0", 0, "")
+	fixture.File("listing.json", cmd[0], cmd[1:]...)
+	ex := gatedFixture{Fixture: fixture, lines: 3, release: make(chan struct{})}
+	sink := &recordingSink{written: make(chan string, 100)}
+
+	ctx := context.Background()
+	done := make(chan bool)
+	go func() {
+		_, failed := streamTargets(ctx, testConfig(), ex, sink, []osdTarget{{id: "0", pod: "pod-0"}}, nil, nil, dir, false, testLogger())
+		done <- failed
+	}()
+
+	// Only the first lines are out yet, so their batch must not wait for
+	// the rest of the listing
+	timeout := time.After(5 * time.Second)
+waitFirst:
+	for {
+		select {
+		case write := <-sink.written:
+			if write == "objects 0 1.0 a,b" {
+				break waitFirst
+			}
+		case <-done:
+			t.Fatal("listing finished while it was held back")
+		case <-timeout:
+			t.Fatal("first batch was not written before the rest of the listing was read")
+		}
+	}
+	close(ex.release)
+	if failed := <-done; failed {
+		t.Fatal("import failed")
+	}
+
+	want := []string{
+		"osd 0",
+		"pg 0 1.0",
+		"objects 0 1.0 a,b",
+		"objects 0 1.0 c",
+		"pg 0 1.1",
+		"objects 0 1.1 d,e",
+	}
+	if got := sink.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("writes = %q, want %q", got, want)
+	}
+
+	saved, err := os.ReadFile(filepath.Join(dir, "osd-0-pgs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(saved) != listing {
+		t.Errorf("saved listing = %q, want %q", saved, listing)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "osd-0-pgs.json.partial")); !os.IsNotExist(err) {
+		t.Errorf("partial listing left behind: %v", err)
+	}
+}
+
+func TestOpenListingFailureLeavesOnlyPartial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "osd-0-pgs.json")
+	output := listingLine("1.0", "a") + "\n"
+	listErr := errors.New("exit status 1")
+
+	listing, _, err := openListing(context.Background(), failingStreamer{output, listErr}, "rook-ceph", "pod-0", 0, path, false, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(listing); err != nil {
+		t.Fatal(err)
+	}
+	if err := listing.Close(); err != listErr {
+		t.Errorf("Close() = %v, want %v", err, listErr)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("failed listing saved as %s: %v", path, err)
+	}
+	partial, err := os.ReadFile(path + ".partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(partial) != output {
+		t.Errorf("partial listing = %q, want %q", partial, output)
+	}
+}
+
+func TestTeeListingClose(t *testing.T) {
+	streamErr := errors.New("exit status 1")
+	tests := []struct {
+		name       string
+		streamErr  error
+		fileFails  bool
+		wantErr    string
+		wantSaved  bool
+		wantRemain bool // the .partial file
+	}{
+		{name: "both succeed", wantSaved: true},
+		{name: "stream fails", streamErr: streamErr, wantErr: streamErr.Error(), wantRemain: true},
+		{name: "file fails", fileFails: true, wantErr: "failed to write PGs file", wantRemain: true},
+		{name: "stream failure comes first", streamErr: streamErr, fileFails: true, wantErr: streamErr.Error(), wantRemain: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "osd-0-pgs.json")
+			file, err := os.Create(path + ".partial")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.fileFails {
+				// Closing it again fails
+				file.Close()
+			}
+			tee := &teeListing{Reader: strings.NewReader(""), stream: failingStream{err: tt.streamErr}, file: file, path: path}
+
+			err = tee.Close()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Close() = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Close() = %v, want %q", err, tt.wantErr)
+			}
+			if err := tee.Close(); err != nil {
+				t.Errorf("second Close() = %v", err)
+			}
+
+			if _, err := os.Stat(path); (err == nil) != tt.wantSaved {
+				t.Errorf("listing saved = %v, want %v", err == nil, tt.wantSaved)
+			}
+			if _, err := os.Stat(path + ".partial"); (err == nil) != tt.wantRemain {
+				t.Errorf("partial listing left = %v, want %v", err == nil, tt.wantRemain)
+			}
+		})
+	}
+}
+
+// queuePGs queues a MergePG of each of pgs for r under key, so the writes
+// record the order they were applied in.
+func queuePGs(p *writePipeline, r *osdImport, key string, pgs ...string) {
+	for _, pgid := range pgs {
+		pgid := pgid
+		p.queue(r, key, func(ctx context.Context, w graphWriter) error {
+			return w.MergePG(ctx, pgid, r.target.id, nil)
+		})
+	}
+}
+
+// writesOf returns the writes for OSD osdID, in the order they were applied.
+func writesOf(writes []string, osdID string) []string {
+	var of []string
+	for _, write := range writes {
+		if strings.HasPrefix(write, "pg "+osdID+" ") {
+			of = append(of, write)
+		}
+	}
+	return of
+}
+
+func TestWritePipelineKeepsKeyOrder(t *testing.T) {
+	p := newWritePipeline(context.Background(), &recordingSink{}, 4, 2, testLogger())
+	r := &osdImport{target: osdTarget{id: "0"}}
+
+	var mu sync.Mutex
+	applied := make(map[string][]int)
+	var want []int
+	for n := 0; n < 20; n++ {
+		want = append(want, n)
+		for pg := 0; pg < 8; pg++ {
+			key, n := pgKey("0", fmt.Sprintf("1.%d", pg)), n
+			p.queue(r, key, func(ctx context.Context, w graphWriter) error {
+				mu.Lock()
+				defer mu.Unlock()
+				applied[key] = append(applied[key], n)
+				return nil
+			})
+		}
+	}
+	p.close()
+
+	if len(applied) != 8 {
+		t.Errorf("writes applied for %d keys, want 8", len(applied))
+	}
+	for key, got := range applied {
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("writes for %s applied in order %v, want %v", key, got, want)
+		}
+	}
+	if err := r.failure(); err != nil {
+		t.Errorf("failure() = %v", err)
+	}
+}
+
+func TestWritePipelineStopsAtFailedWrite(t *testing.T) {
+	writeErr := errors.New("connection reset")
+	sink := &recordingSink{fail: map[string]error{"pg 0 1.2": writeErr}}
+	p := newWritePipeline(context.Background(), sink, 2, 2, testLogger())
+
+	failing := &osdImport{target: osdTarget{id: "0"}}
+	other := &osdImport{target: osdTarget{id: "1"}}
+	queuePGs(p, failing, pgKey("0", "1.0"), "1.0", "1.1", "1.2", "1.3", "1.4")
+	queuePGs(p, other, pgKey("1", "1.0"), "1.0", "1.1", "1.2", "1.3", "1.4")
+	p.close()
+
+	writes := sink.recorded()
+	want := []string{"pg 0 1.0", "pg 0 1.1", "pg 0 1.2"}
+	if got := writesOf(writes, "0"); !reflect.DeepEqual(got, want) {
+		t.Errorf("failing OSD writes = %q, want %q", got, want)
+	}
+	if err := failing.failure(); err != writeErr {
+		t.Errorf("failure() = %v, want %v", err, writeErr)
+	}
+
+	if got := writesOf(writes, "1"); len(got) != 5 {
+		t.Errorf("other OSD writes = %q, want all 5", got)
+	}
+	if err := other.failure(); err != nil {
+		t.Errorf("other OSD failed: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"main/internal/executor"
+	"main/internal/rook"
+)
+
+// osdTarget is an OSD to import and the pod its objects are listed from.
+type osdTarget struct {
+	id  string
+	pod string
+	err error // why the OSD cannot be listed, if it cannot
+}
+
+// resolveTargets turns -pod, -osds or -all-osds into the OSDs to import.
+// OSDs without a usable maintenance pod are returned with err set so they
+// show up in the summary instead of silently disappearing.
+func resolveTargets(ctx context.Context, cfg *config, ex executor.Executor) ([]osdTarget, error) {
+	if cfg.osdPod != "" {
+		// Extract OSD ID from pod name
+		osdID, err := extractOSDID(cfg.osdPod)
+		if err != nil {
+			return nil, err
+		}
+
+		// Validate OSD pod exists
+		if err := validateOSDPod(ctx, ex, cfg.osdPod, cfg.namespace); err != nil {
+			return nil, err
+		}
+		return []osdTarget{{id: osdID, pod: cfg.osdPod}}, nil
+	}
+
+	ids := cfg.osds
+	if cfg.allOSDs {
+		found, err := rook.MaintenanceOSDs(ctx, ex, cfg.namespace)
+		if err != nil {
+			return nil, fmt.Errorf("discovering maintenance OSDs: %v", err)
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("no OSD has a maintenance deployment in namespace %s", cfg.namespace)
+		}
+		ids = nil
+		for _, id := range found {
+			ids = append(ids, strconv.Itoa(id))
+		}
+	}
+
+	var targets []osdTarget
+	for _, id := range ids {
+		n, _ := strconv.Atoi(id)
+		pod, err := rook.FindMaintenancePod(ctx, ex, cfg.namespace, n)
+		targets = append(targets, osdTarget{id: id, pod: pod, err: err})
+	}
+	return targets, nil
+}
+
+// extractOSDID reads the OSD ID out of an OSD pod name such as
+// rook-ceph-osd-3-maintenance-abc.
+func extractOSDID(osdPod string) (string, error) {
+	re := regexp.MustCompile(`.*osd-([0-9]+).*`)
+	matches := re.FindStringSubmatch(osdPod)
+	if len(matches) < 2 {
+		return "", fmt.Errorf("cannot extract OSD ID from pod name: %s", osdPod)
+	}
+	return matches[1], nil
+}
+
+// validateOSDPod checks that an explicitly given -pod exists. It may be any
+// pod of the OSD, so it is not held to rook.FindMaintenancePod's checks.
+func validateOSDPod(ctx context.Context, ex executor.Executor, osdPod, namespace string) error {
+	if _, err := ex.Run(ctx, "kubectl", "-n", namespace, "get", "pod", osdPod); err != nil {
+		return fmt.Errorf("OSD pod %s not found in namespace %s", osdPod, namespace)
+	}
+	return nil
+}
+
+func printSummary(imports []*osdImport) {
+	fmt.Println("\n=== Per-OSD Summary ===")
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "OSD\tPod\tPGs\tObjects\tTook\tStatus")
+	for _, r := range imports {
+		status := "OK"
+		if err := r.failure(); err != nil {
+			status = "FAILED: " + err.Error()
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", r.target.id, r.target.pod, r.pgs, r.objects, r.duration.Round(time.Second), status)
+	}
+	_ = w.Flush()
+}
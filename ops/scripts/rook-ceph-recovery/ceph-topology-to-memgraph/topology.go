@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"main/internal/ceph"
+	"main/internal/objectstore"
+)
+
+// objectSet is a set of object IDs (objectstore.Object.FullID).
+type objectSet map[string]struct{}
+
+// topology is which OSDs hold copies of which objects of each PG, held in
+// memory. It answers the analysis reports with set operations, so they need
+// no graph database.
+type topology struct {
+	mu   sync.Mutex
+	osds map[string]map[string]interface{} // OSD node properties, by OSD ID
+	pgs  map[string]*topologyPG
+}
+
+type topologyPG struct {
+	// copies holds the objects each OSD's copy of the PG has
+	copies map[string]objectSet
+	// details holds what was dumped of each copy of an object, by object
+	// and then OSD
+	details map[string]map[string]copyDetails
+}
+
+type copyDetails struct {
+	version string
+	size    int64
+}
+
+func newTopology() *topology {
+	return &topology{
+		osds: make(map[string]map[string]interface{}),
+		pgs:  make(map[string]*topologyPG),
+	}
+}
+
+// copy returns an OSD's copy of a PG, adding it if it is new.
+func (t *topology) copy(pgid, osdID string) objectSet {
+	pg := t.pgs[pgid]
+	if pg == nil {
+		pg = &topologyPG{copies: make(map[string]objectSet), details: make(map[string]map[string]copyDetails)}
+		t.pgs[pgid] = pg
+	}
+	if pg.copies[osdID] == nil {
+		pg.copies[osdID] = make(objectSet)
+	}
+	return pg.copies[osdID]
+}
+
+// objects returns every object of the PG that any OSD holds.
+func (pg *topologyPG) objects() objectSet {
+	all := make(objectSet)
+	for _, objects := range pg.copies {
+		for id := range objects {
+			all[id] = struct{}{}
+		}
+	}
+	return all
+}
+
+// holders returns the OSDs holding a copy of an object, sorted.
+func (pg *topologyPG) holders(object string) []string {
+	var osds []string
+	for osd, objects := range pg.copies {
+		if _, ok := objects[object]; ok {
+			osds = append(osds, osd)
+		}
+	}
+	sort.Strings(osds)
+	return osds
+}
+
+// sortedKeys returns the keys of a map keyed by ID, sorted as strings to
+// match the order of the Cypher reports.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// singleCopy is the single-copy report: objects only one OSD holds.
+func (t *topology) singleCopy() [][]interface{} {
+	var rows [][]interface{}
+	for _, pgid := range sortedKeys(t.pgs) {
+		if ceph.IsShardPGID(pgid) {
+			continue
+		}
+		pg := t.pgs[pgid]
+		for _, object := range sortedKeys(pg.objects()) {
+			holders := pg.holders(object)
+			if len(holders) == 1 {
+				rows = append(rows, []interface{}{pgid, object, holders[0], t.osds[holders[0]]["host"]})
+			}
+		}
+	}
+	return rows
+}
+
+// pgObjectSets is the pg-object-sets report: copies of PGs missing objects
+// other copies have.
+func (t *topology) pgObjectSets() [][]interface{} {
+	var rows [][]interface{}
+	for _, pgid := range sortedKeys(t.pgs) {
+		pg := t.pgs[pgid]
+		total := int64(len(pg.objects()))
+		for _, osd := range sortedKeys(pg.copies) {
+			held := int64(len(pg.copies[osd]))
+			if held < total {
+				rows = append(rows, []interface{}{pgid, osd, held, total, total - held})
+			}
+		}
+	}
+	return rows
+}
+
+// copyMismatch is the copy-mismatch report: objects whose dumped copies
+// differ in version or size.
+func (t *topology) copyMismatch() [][]interface{} {
+	var rows [][]interface{}
+	for _, pgid := range sortedKeys(t.pgs) {
+		pg := t.pgs[pgid]
+		for _, object := range sortedKeys(pg.details) {
+			versions := make(map[string]bool)
+			sizes := make(map[int64]bool)
+			var copies []interface{}
+			for _, osd := range sortedKeys(pg.details[object]) {
+				d := pg.details[object][osd]
+				versions[d.version] = true
+				sizes[d.size] = true
+				copies = append(copies, fmt.Sprintf("%s=%s/%d", osd, d.version, d.size))
+			}
+			if len(versions) > 1 || len(sizes) > 1 {
+				rows = append(rows, []interface{}{pgid, object, copies})
+			}
+		}
+	}
+	return rows
+}
+
+// noSurvivingReplica is the no-surviving-replica report: PGs no copy of which
+// holds every object.
+func (t *topology) noSurvivingReplica() [][]interface{} {
+	var rows [][]interface{}
+	for _, pgid := range sortedKeys(t.pgs) {
+		if ceph.IsShardPGID(pgid) {
+			continue
+		}
+		pg := t.pgs[pgid]
+		total := int64(len(pg.objects()))
+		best := int64(0)
+		var copies []interface{}
+		for _, osd := range sortedKeys(pg.copies) {
+			held := int64(len(pg.copies[osd]))
+			if held > best {
+				best = held
+			}
+			copies = append(copies, fmt.Sprintf("%s=%d", osd, held))
+		}
+		if best < total {
+			rows = append(rows, []interface{}{pgid, total, best, copies})
+		}
+	}
+	return rows
+}
+
+// reports runs analyses over t.
+func (t *topology) reports(analyses []analysis, limit int) []Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var reports []Report
+	for _, a := range analyses {
+		reports = append(reports, newReport(a, a.columns, a.offline(t), limit))
+	}
+	return reports
+}
+
+// topologyWriter applies an import's writes to a topology, keeping only what
+// the reports use.
+type topologyWriter struct {
+	t *topology
+}
+
+func (w topologyWriter) CreateOSDNode(ctx context.Context, osdID string, props map[string]interface{}) error {
+	w.t.mu.Lock()
+	defer w.t.mu.Unlock()
+	if w.t.osds[osdID] == nil {
+		w.t.osds[osdID] = make(map[string]interface{})
+	}
+	setProps(w.t.osds[osdID], props)
+	return nil
+}
+
+func (w topologyWriter) MergePG(ctx context.Context, pgID, osdID string, props map[string]interface{}) error {
+	w.t.mu.Lock()
+	defer w.t.mu.Unlock()
+	w.t.copy(pgID, osdID)
+	return nil
+}
+
+func (w topologyWriter) SetCopyProperties(ctx context.Context, pgID, osdID string, props map[string]interface{}) error {
+	return nil
+}
+
+func (w topologyWriter) WriteObjects(ctx context.Context, objects []listedObject, pgID, osdID string) error {
+	w.t.mu.Lock()
+	defer w.t.mu.Unlock()
+	held := w.t.copy(pgID, osdID)
+	for _, obj := range objects {
+		held[obj.Object.FullID()] = struct{}{}
+	}
+	return nil
+}
+
+func (w topologyWriter) SetObjectDetails(ctx context.Context, objects []listedObject, osdID string) error {
+	w.t.mu.Lock()
+	defer w.t.mu.Unlock()
+	for _, obj := range objects {
+		pg := w.t.pgs[obj.PGID]
+		if obj.Dump == nil || pg == nil {
+			continue
+		}
+		id := obj.Object.FullID()
+		if pg.details[id] == nil {
+			pg.details[id] = make(map[string]copyDetails)
+		}
+		pg.details[id][osdID] = copyDetails{version: obj.Dump.Info.Version.String(), size: obj.Dump.Info.Size}
+	}
+	return nil
+}
+
+func (w topologyWriter) Close(ctx context.Context) error {
+	return nil
+}
+
+// topologySink builds the topology in memory and prints every report over it
+// once the import is done, for when there is no graph database at hand.
+type topologySink struct {
+	topology *topology
+	limit    int
+}
+
+func (s *topologySink) Prepare(ctx context.Context) error {
+	return nil
+}
+
+func (s *topologySink) newWriter(ctx context.Context) graphWriter {
+	return topologyWriter{s.topology}
+}
+
+func (s *topologySink) Finish(ctx context.Context) error {
+	fmt.Println()
+	noteFewOSDs(os.Stdout, analyses, len(s.topology.osds))
+	return writeReports(os.Stdout, "table", s.topology.reports(analyses, s.limit))
+}
+
+func (s *topologySink) Close(ctx context.Context) error {
+	return nil
+}
+
+// loadTopology builds a topology from saved listings, as import-file finds
+// them in paths. OSD hosts come from a saved OSD tree if there is one; copies
+// are never dumped, so the copy-mismatch report stays empty.
+func loadTopology(ctx context.Context, paths []string, osd string) (*topology, error) {
+	c := newCapture("", osd)
+	for _, path := range paths {
+		if err := c.add(path); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.listings) == 0 {
+		return nil, fmt.Errorf("no object listings found")
+	}
+
+	var meta *clusterMetadata
+	if c.metadata {
+		cmd := ceph.OSDTreeCommand()
+		if out, err := c.fixture.Run(ctx, cmd[0], cmd[1:]...); err == nil {
+			var tree ceph.OSDTree
+			if err := json.Unmarshal(out, &tree); err != nil {
+				return nil, fmt.Errorf("parsing OSD tree: %v", err)
+			}
+			meta = newClusterMetadata()
+			meta.osds = tree.Placements()
+		}
+	}
+
+	t := newTopology()
+	w := topologyWriter{t}
+	for osd, files := range c.listings {
+		osdID := strconv.Itoa(osd)
+		if err := w.CreateOSDNode(ctx, osdID, meta.osdProperties(osdID)); err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if err := loadListing(ctx, w, osdID, file); err != nil {
+				return nil, fmt.Errorf("reading %s: %v", file, err)
+			}
+		}
+	}
+	return t, nil
+}
+
+// loadListing adds the objects of one saved --op list output to the OSD's
+// copies.
+func loadListing(ctx context.Context, w topologyWriter, osdID, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		entry, err := objectstore.ParseLine(line)
+		if err != nil || entry.Object.OID == "" {
+			continue
+		}
+		if err := w.WriteObjects(ctx, []listedObject{{Entry: entry}}, entry.PGID, osdID); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
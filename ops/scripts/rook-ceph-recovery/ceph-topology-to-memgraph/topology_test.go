@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testReportTopology holds replicated PGs and the shard PGs of an EC pool,
+// whose shards are different objects on each OSD.
+func testReportTopology() *topology {
+	t := newTestTopology(map[string]map[string][]string{
+		"1": {
+			"1.0":    {"1:a", "1:b"},
+			"1.1":    {"1:c"},
+			"2.1as0": {"2:x:s0", "2:y:s0"},
+		},
+		"2": {
+			"1.0":    {"1:a"},
+			"1.1":    {"1:d"},
+			"2.1as1": {"2:x:s1"},
+		},
+		"3": {
+			"2.1as0": {"2:x:s0"},
+			"2.1as1": {"2:y:s1"},
+		},
+	})
+	t.osds["1"]["host"] = "node-a"
+	t.osds["2"]["host"] = "node-b"
+
+	// Dumped copies of 1.0's objects; only a's differ
+	t.pgs["1.0"].details = map[string]map[string]copyDetails{
+		"1:a": {"1": {version: "10'1", size: 10}, "2": {version: "10'2", size: 10}},
+		"1:b": {"1": {version: "10'3", size: 20}},
+	}
+	t.pgs["1.1"].details = map[string]map[string]copyDetails{
+		"1:c": {"1": {version: "10'4", size: 30}, "2": {version: "10'4", size: 30}},
+	}
+	return t
+}
+
+func TestTopologyReports(t *testing.T) {
+	// EC shard PGs are left out of single-copy and no-surviving-replica:
+	// each shard is on one OSD by design
+	want := map[string][][]interface{}{
+		"single-copy": {
+			{"1.0", "1:b", "1", "node-a"},
+			{"1.1", "1:c", "1", "node-a"},
+			{"1.1", "1:d", "2", "node-b"},
+		},
+		"pg-object-sets": {
+			{"1.0", "2", int64(1), int64(2), int64(1)},
+			{"1.1", "1", int64(1), int64(2), int64(1)},
+			{"1.1", "2", int64(1), int64(2), int64(1)},
+			{"2.1as0", "3", int64(1), int64(2), int64(1)},
+			{"2.1as1", "2", int64(1), int64(2), int64(1)},
+			{"2.1as1", "3", int64(1), int64(2), int64(1)},
+		},
+		"copy-mismatch": {
+			{"1.0", "1:a", []interface{}{"1=10'1/10", "2=10'2/10"}},
+		},
+		"no-surviving-replica": {
+			{"1.1", int64(2), int64(1), []interface{}{"1=1", "2=1"}},
+		},
+	}
+
+	reports := testReportTopology().reports(analyses, defaultReportLimit)
+	if len(reports) != len(want) {
+		t.Fatalf("got %d reports, want %d", len(reports), len(want))
+	}
+	for _, r := range reports {
+		if r.Truncated {
+			t.Errorf("%s truncated", r.Name)
+		}
+		if !reflect.DeepEqual(r.values, want[r.Name]) {
+			t.Errorf("%s rows = %v, want %v", r.Name, r.values, want[r.Name])
+		}
+	}
+}
+
+func TestTopologyReportsLimit(t *testing.T) {
+	for _, r := range testReportTopology().reports(analyses, 1) {
+		if len(r.values) > 1 {
+			t.Errorf("%s has %d rows, want at most 1", r.Name, len(r.values))
+		}
+		if r.Name == "single-copy" && !r.Truncated {
+			t.Errorf("%s not marked truncated", r.Name)
+		}
+	}
+}
@@ -45,99 +45,34 @@ echo "Collecting PG info for OSD $OSD_ID in namespace $NAMESPACE"
 echo "PG IDs: $PG_IDS"
 echo "Output file: $OUTPUT_FILE"
 
-# Find the maintenance pod for the specified OSD
-echo "Finding maintenance pod for OSD $OSD_ID..."
-MAINTENANCE_POD=$(kubectl get pods -n "$NAMESPACE" -l "app=rook-ceph-osd,ceph_daemon_id=$OSD_ID" -o jsonpath='{.items[0].metadata.name}' 2>/dev/null)
+# The template is rendered by reconcile-dodgy-pgs, which also records the
+# comparison against the cluster and the authoritative-copy recommendation.
+# It is built rather than run with go run so that its exit status survives,
+# and run from the current directory so that its pg_*.json captures are
+# written to the directory the script is run from, not to the source tree.
+SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
+BUILD_DIR="$(mktemp -d)"
+trap 'rm -rf "$BUILD_DIR"' EXIT
+go -C "$SCRIPT_DIR" build -o "$BUILD_DIR/reconcile-dodgy-pgs" ./reconcile-dodgy-pgs
+
+STATUS=0
+"$BUILD_DIR/reconcile-dodgy-pgs" -namespace "$NAMESPACE" -pgs "$PG_IDS" -osds "$OSD_ID" -output=markdown > "$OUTPUT_FILE" || STATUS=$?
+case $STATUS in
+    0)
+        echo "✅ All PG info collected successfully!"
+        ;;
+    1)
+        echo "⚠ Warning: some PG info could not be collected, see UNAVAILABLE entries in $OUTPUT_FILE"
+        ;;
+    *)
+        echo "❌ Error: reconcile-dodgy-pgs failed with exit status $STATUS; $OUTPUT_FILE is incomplete" >&2
+        exit "$STATUS"
+        ;;
+esac
 
-if [[ -z "$MAINTENANCE_POD" ]]; then
-    echo "Error: Could not find maintenance pod for OSD $OSD_ID in namespace $NAMESPACE"
-    echo "Make sure the OSD is in maintenance mode and the pod exists"
-    exit 1
-fi
-
-echo "Found maintenance pod: $MAINTENANCE_POD"
-
-# Initialize output file (empty)
-> "$OUTPUT_FILE"
-
-# Convert comma-separated PG IDs to array
-IFS=',' read -ra PG_ARRAY <<< "$PG_IDS"
-
-# Process each PG ID
-for PGID in "${PG_ARRAY[@]}"; do
-    # Trim whitespace
-    PGID=$(echo "$PGID" | xargs)
-
-    echo "Processing PG ID: $PGID"
-
-    # Add PG section to markdown file using your exact format
-    cat >> "$OUTPUT_FILE" << EOF
-# $PGID - OSD $OSD_ID
-## OSD $OSD_ID info before import
-
-\`\`\`json
-EOF
-
-    # Get OSD info
-    echo "  Collecting OSD info for PG $PGID..."
-
-    if kubectl exec -n "$NAMESPACE" "$MAINTENANCE_POD" -- ceph-objectstore-tool --data-path /var/lib/ceph/osd/ceph-$OSD_ID --pgid "$PGID" --op info 2>/dev/null >> "$OUTPUT_FILE"; then
-        echo "  ✓ OSD info collected successfully"
-    else
-        echo "  ⚠ Warning: Failed to collect OSD info for PG $PGID"
-        echo "Error: Failed to collect OSD info" >> "$OUTPUT_FILE"
-    fi
-
-    cat >> "$OUTPUT_FILE" << EOF
-\`\`\`
-
-## Cluster info before import
-
-\`\`\`json
-EOF
-
-    # Get cluster info
-    echo "  Collecting cluster info for PG $PGID..."
-
-    if kubectl rook-ceph ceph pg "$PGID" query 2>/dev/null >> "$OUTPUT_FILE"; then
-        echo "  ✓ Cluster info collected successfully"
-    else
-        echo "  ⚠ Warning: Failed to collect cluster info for PG $PGID"
-        echo "Error: Failed to collect cluster info" >> "$OUTPUT_FILE"
-    fi
-
-    # Complete the template for this PG with your exact format
-    cat >> "$OUTPUT_FILE" << EOF
-\`\`\`
-
-## Import output
-
-\`\`\`bash
-
-\`\`\`
-
-## OSD $OSD_ID info after import
-
-\`\`\`bash
-
-\`\`\`
-
-## Cluster info after import
-
-\`\`\`bash
-
-\`\`\`
-
-EOF
-
-    echo "  ✓ PG $PGID section completed"
-done
-
-echo
-echo "✅ All PG info collected successfully!"
 echo "Output written to: $OUTPUT_FILE"
 echo
 echo "Next steps:"
 echo "1. Review the collected info in $OUTPUT_FILE"
 echo "2. Perform your PG import operations"
-echo "3. Fill in the remaining sections manually after import"
\ No newline at end of file
+echo "3. Fill in the remaining sections manually after import"
@@ -2,4 +2,7 @@ module main
 
 go 1.21.6
 
-require github.com/neo4j/neo4j-go-driver/v5 v5.28.1
+require (
+	github.com/neo4j/neo4j-go-driver/v5 v5.28.1
+	gopkg.in/yaml.v3 v3.0.1
+)
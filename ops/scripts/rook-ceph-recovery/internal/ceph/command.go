@@ -0,0 +1,48 @@
+package ceph
+
+import "strconv"
+
+// Cluster-wide commands go through the rook-ceph kubectl plugin, which runs
+// them in the operator pod with the cluster's admin keyring.
+
+func OSDTreeCommand() []string {
+	return []string{"kubectl", "rook-ceph", "ceph", "osd", "tree", "-f", "json"}
+}
+
+func PGDumpCommand() []string {
+	return []string{"kubectl", "rook-ceph", "ceph", "pg", "dump", "pgs", "-f", "json"}
+}
+
+func PoolsCommand() []string {
+	return []string{"kubectl", "rook-ceph", "ceph", "osd", "lspools", "-f", "json"}
+}
+
+func FSIDCommand() []string {
+	return []string{"kubectl", "rook-ceph", "ceph", "fsid", "-f", "json"}
+}
+
+// InfoCommand prints an OSD's own pg_info_t for a PG, from inside its
+// maintenance pod.
+func InfoCommand(ns, pod string, osd int, pgid string) []string {
+	return []string{"kubectl", "-n", ns, "exec", pod, "--", "ceph-objectstore-tool", "--data-path", DataPath(osd), "--pgid", pgid, "--op", "info"}
+}
+
+// ListCommand lists the objects of a PG on an OSD, or of every PG on it if
+// pgid is empty.
+func ListCommand(ns, pod string, osd int, pgid string) []string {
+	cmd := []string{"kubectl", "-n", ns, "exec", pod, "--", "ceph-objectstore-tool", "--data-path", DataPath(osd)}
+	if pgid != "" {
+		cmd = append(cmd, "--pgid", pgid)
+	}
+	return append(cmd, "--op", "list")
+}
+
+// DumpCommand dumps one object, named by its line of ListCommand output.
+func DumpCommand(ns, pod string, osd int, pgid, object string) []string {
+	return []string{"kubectl", "-n", ns, "exec", pod, "--", "ceph-objectstore-tool", "--data-path", DataPath(osd), "--pgid", pgid, object, "dump"}
+}
+
+// DataPath is where Rook mounts an OSD's data inside its pods.
+func DataPath(osd int) string {
+	return "/var/lib/ceph/osd/ceph-" + strconv.Itoa(osd)
+}
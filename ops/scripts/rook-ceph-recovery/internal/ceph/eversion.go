@@ -0,0 +1,80 @@
+package ceph
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Eversion is Ceph's eversion_t: the epoch a log entry was written in and its
+// version within the PG, printed as "epoch'version".
+type Eversion struct {
+	Epoch   uint32
+	Version uint64
+}
+
+// ParseEversion parses the "epoch'version" form Ceph prints, e.g. "120'45".
+func ParseEversion(s string) (Eversion, error) {
+	epoch, version, ok := strings.Cut(s, "'")
+	if !ok {
+		return Eversion{}, fmt.Errorf("invalid eversion %q: missing '", s)
+	}
+
+	e, err := strconv.ParseUint(epoch, 10, 32)
+	if err != nil {
+		return Eversion{}, fmt.Errorf("invalid eversion %q: epoch: %v", s, err)
+	}
+	v, err := strconv.ParseUint(version, 10, 64)
+	if err != nil {
+		return Eversion{}, fmt.Errorf("invalid eversion %q: version: %v", s, err)
+	}
+
+	return Eversion{Epoch: uint32(e), Version: v}, nil
+}
+
+func (e Eversion) String() string {
+	return fmt.Sprintf("%d'%d", e.Epoch, e.Version)
+}
+
+// Compare orders eversions the way Ceph does: by epoch, then by version.
+// It returns -1, 0 or +1.
+func (e Eversion) Compare(o Eversion) int {
+	switch {
+	case e.Epoch < o.Epoch:
+		return -1
+	case e.Epoch > o.Epoch:
+		return 1
+	case e.Version < o.Version:
+		return -1
+	case e.Version > o.Version:
+		return 1
+	}
+	return 0
+}
+
+func (e Eversion) Less(o Eversion) bool {
+	return e.Compare(o) < 0
+}
+
+func (e Eversion) IsZero() bool {
+	return e == Eversion{}
+}
+
+func (e Eversion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.String())
+}
+
+func (e *Eversion) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("eversion must be a string: %v", err)
+	}
+
+	parsed, err := ParseEversion(s)
+	if err != nil {
+		return err
+	}
+	*e = parsed
+	return nil
+}
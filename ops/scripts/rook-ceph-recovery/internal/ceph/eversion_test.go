@@ -0,0 +1,95 @@
+package ceph
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseEversion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Eversion
+		wantErr bool
+	}{
+		{in: "120'45", want: Eversion{Epoch: 120, Version: 45}},
+		{in: "0'0", want: Eversion{}},
+		{in: "4294967295'18446744073709551615", want: Eversion{Epoch: 4294967295, Version: 18446744073709551615}},
+		{in: "", wantErr: true},
+		{in: "120", wantErr: true},
+		{in: "120'", wantErr: true},
+		{in: "'45", wantErr: true},
+		{in: "4294967296'1", wantErr: true},
+		{in: "-1'45", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseEversion(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseEversion(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseEversion(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseEversion(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if got.String() != tt.in {
+			t.Errorf("ParseEversion(%q).String() = %q", tt.in, got.String())
+		}
+	}
+}
+
+func TestEversionCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"120'45", "120'45", 0},
+		{"120'45", "120'46", -1},
+		{"120'46", "120'45", 1},
+		// The epoch wins over the version, and both compare as numbers
+		{"119'900", "120'1", -1},
+		{"121'1", "120'900", 1},
+		{"9'1", "10'1", -1},
+		{"0'0", "1'0", -1},
+	}
+
+	for _, tt := range tests {
+		a, _ := ParseEversion(tt.a)
+		b, _ := ParseEversion(tt.b)
+		if got := a.Compare(b); got != tt.want {
+			t.Errorf("%s.Compare(%s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := a.Less(b); got != (tt.want < 0) {
+			t.Errorf("%s.Less(%s) = %v", tt.a, tt.b, got)
+		}
+	}
+}
+
+func TestEversionJSON(t *testing.T) {
+	var v struct {
+		LastUpdate Eversion `json:"last_update"`
+	}
+	if err := json.Unmarshal([]byte(`{"last_update":"120'45"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.LastUpdate != (Eversion{Epoch: 120, Version: 45}) {
+		t.Errorf("unmarshaled %+v", v.LastUpdate)
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"last_update":"120'45"}` {
+		t.Errorf("marshaled %s", out)
+	}
+
+	if err := json.Unmarshal([]byte(`{"last_update":120}`), &v); err == nil {
+		t.Error("unmarshaling a number succeeded, want an error")
+	}
+}
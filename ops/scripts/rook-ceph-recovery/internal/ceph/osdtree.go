@@ -0,0 +1,84 @@
+package ceph
+
+import (
+	"sort"
+	"strings"
+)
+
+// OSDTree is the output of `ceph osd tree -f json`: the CRUSH hierarchy, plus
+// OSDs that exist but are not placed in it (stray).
+type OSDTree struct {
+	Nodes []TreeNode `json:"nodes"`
+	Stray []TreeNode `json:"stray"`
+}
+
+// TreeNode is a CRUSH bucket (negative ID) or an OSD.
+type TreeNode struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Children    []int   `json:"children"`
+	DeviceClass string  `json:"device_class"`
+	CrushWeight float64 `json:"crush_weight"`
+	Reweight    float64 `json:"reweight"`
+	Status      string  `json:"status"`
+}
+
+// OSDPlacement is where an OSD sits in CRUSH and whether it is up and in.
+type OSDPlacement struct {
+	ID          int
+	Host        string
+	DeviceClass string
+	// Location maps CRUSH bucket types to the buckets containing the OSD,
+	// e.g. host=node1 root=default.
+	Location    map[string]string
+	CrushWeight float64
+	Reweight    float64
+	Up          bool
+	// In is derived from the reweight: an OSD marked out has a reweight of 0.
+	In bool
+}
+
+// LocationString formats Location the way `ceph osd find` and CRUSH rules
+// spell it, sorted by bucket type so it is stable.
+func (p OSDPlacement) LocationString() string {
+	var parts []string
+	for typ, name := range p.Location {
+		parts = append(parts, typ+"="+name)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// Placements returns the placement of every OSD in the tree, keyed by ID.
+func (t OSDTree) Placements() map[int]OSDPlacement {
+	parents := make(map[int]TreeNode)
+	for _, node := range t.Nodes {
+		for _, child := range node.Children {
+			parents[child] = node
+		}
+	}
+
+	placements := make(map[int]OSDPlacement)
+	for _, node := range append(t.Nodes, t.Stray...) {
+		if node.Type != "osd" {
+			continue
+		}
+
+		p := OSDPlacement{
+			ID:          node.ID,
+			DeviceClass: node.DeviceClass,
+			Location:    make(map[string]string),
+			CrushWeight: node.CrushWeight,
+			Reweight:    node.Reweight,
+			Up:          node.Status == "up",
+			In:          node.Reweight > 0,
+		}
+		for parent, ok := parents[node.ID]; ok; parent, ok = parents[parent.ID] {
+			p.Location[parent.Type] = parent.Name
+		}
+		p.Host = p.Location["host"]
+		placements[node.ID] = p
+	}
+	return placements
+}
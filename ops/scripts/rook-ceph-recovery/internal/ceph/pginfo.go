@@ -0,0 +1,117 @@
+// Package ceph decodes the JSON that Ceph's tools print about PGs and OSDs,
+// and builds the commands that print it.
+package ceph
+
+// PGInfo is a dump of Ceph's pg_info_t, as printed by
+// `ceph-objectstore-tool --op info` and embedded in `ceph pg <id> query`.
+type PGInfo struct {
+	PGID             string    `json:"pgid"`
+	LastUpdate       Eversion  `json:"last_update"`
+	LastComplete     Eversion  `json:"last_complete"`
+	LogTail          Eversion  `json:"log_tail"`
+	LastUserVersion  int       `json:"last_user_version"`
+	LastBackfill     string    `json:"last_backfill"`
+	LastEpochStarted int       `json:"last_epoch_started"`
+	Empty            int       `json:"empty"`
+	DNE              int       `json:"dne"`
+	Incomplete       int       `json:"incomplete"`
+	History          PGHistory `json:"history"`
+	Stats            struct {
+		State   string `json:"state"`
+		StatSum struct {
+			NumObjects                 int `json:"num_objects"`
+			NumObjectsMissingOnPrimary int `json:"num_objects_missing_on_primary"`
+			NumObjectsMissing          int `json:"num_objects_missing"`
+			NumObjectsDegraded         int `json:"num_objects_degraded"`
+			NumObjectsUnfound          int `json:"num_objects_unfound"`
+		} `json:"stat_sum"`
+		Version Eversion `json:"version"`
+	} `json:"stats"`
+}
+
+// PGHistory is pg_history_t, the epochs at which the PG last changed interval.
+type PGHistory struct {
+	EpochCreated        int `json:"epoch_created"`
+	LastEpochStarted    int `json:"last_epoch_started"`
+	LastIntervalStarted int `json:"last_interval_started"`
+	LastEpochClean      int `json:"last_epoch_clean"`
+	SameUpSince         int `json:"same_up_since"`
+	SameIntervalSince   int `json:"same_interval_since"`
+	SamePrimarySince    int `json:"same_primary_since"`
+}
+
+// PeerInfo is an entry of peer_info in `ceph pg <id> query`: the primary's
+// record of what a replica last reported.
+type PeerInfo struct {
+	Peer string `json:"peer"`
+	PGInfo
+}
+
+// RecoveryState is one entry of the recovery_state stack in `ceph pg <id> query`.
+// Only the fields that matter for picking an authoritative copy are decoded.
+type RecoveryState struct {
+	Name                 string   `json:"name"`
+	EnterTime            string   `json:"enter_time"`
+	Comment              string   `json:"comment"`
+	Blocked              string   `json:"blocked"`
+	ProbingOSDs          []string `json:"probing_osds"`
+	DownOSDsWeWouldProbe []int    `json:"down_osds_we_would_probe"`
+	PeeringBlockedBy     []struct {
+		OSD     int    `json:"osd"`
+		Comment string `json:"comment"`
+	} `json:"peering_blocked_by"`
+	RecoveryProgress struct {
+		BackfillTargets []string `json:"backfill_targets"`
+	} `json:"recovery_progress"`
+	MightHaveUnfound []struct {
+		OSD    string `json:"osd"`
+		Status string `json:"status"`
+	} `json:"might_have_unfound"`
+}
+
+// PGQuery is the output of `ceph pg <id> query`.
+type PGQuery struct {
+	State         string          `json:"state"`
+	Epoch         int             `json:"epoch"`
+	Up            []int           `json:"up"`
+	Acting        []int           `json:"acting"`
+	Info          PGInfo          `json:"info"`
+	PeerInfo      []PeerInfo      `json:"peer_info"`
+	RecoveryState []RecoveryState `json:"recovery_state"`
+}
+
+// StartedEpoch returns last_epoch_started, preferring the top-level field and
+// falling back to history, since older releases only report it in the latter.
+func (i PGInfo) StartedEpoch() int {
+	if i.LastEpochStarted != 0 {
+		return i.LastEpochStarted
+	}
+	return i.History.LastEpochStarted
+}
+
+// Stat returns the cluster's view of the PG in the form `ceph pg dump` gives
+// it, for when only a query was captured.
+func (q PGQuery) Stat() PGStat {
+	stat := PGStat{
+		PGID:          q.Info.PGID,
+		Version:       q.Info.LastUpdate,
+		State:         q.State,
+		Up:            q.Up,
+		Acting:        q.Acting,
+		UpPrimary:     -1,
+		ActingPrimary: -1,
+	}
+	if len(q.Up) > 0 {
+		stat.UpPrimary = q.Up[0]
+	}
+	if len(q.Acting) > 0 {
+		stat.ActingPrimary = q.Acting[0]
+	}
+
+	sum := q.Info.Stats.StatSum
+	stat.StatSum.NumObjects = sum.NumObjects
+	stat.StatSum.NumObjectsMissing = sum.NumObjectsMissing
+	stat.StatSum.NumObjectsDegraded = sum.NumObjectsDegraded
+	stat.StatSum.NumObjectsUnfound = sum.NumObjectsUnfound
+	return stat
+}
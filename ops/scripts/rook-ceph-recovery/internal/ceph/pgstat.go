@@ -0,0 +1,82 @@
+package ceph
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// PGStat is the part of Ceph's pg_stat_t that the tools use: the cluster's view
+// of a PG as printed by `ceph pg dump`, `ceph pg ls` and `ceph pg dump_stuck`.
+type PGStat struct {
+	PGID          string   `json:"pgid"`
+	Version       Eversion `json:"version"`
+	State         string   `json:"state"`
+	Up            []int    `json:"up"`
+	Acting        []int    `json:"acting"`
+	UpPrimary     int      `json:"up_primary"`
+	ActingPrimary int      `json:"acting_primary"`
+	StatSum       struct {
+		NumBytes           int64 `json:"num_bytes"`
+		NumObjects         int   `json:"num_objects"`
+		NumObjectsMissing  int   `json:"num_objects_missing"`
+		NumObjectsDegraded int   `json:"num_objects_degraded"`
+		NumObjectsUnfound  int   `json:"num_objects_unfound"`
+	} `json:"stat_sum"`
+}
+
+// ParsePGStats decodes a list of pg_stat_t. Depending on the command and
+// release it is a bare array, or wrapped in an object (since Nautilus), and
+// `pg dump` nests it one level further under pg_map.
+func ParsePGStats(data []byte) ([]PGStat, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var stats []PGStat
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return nil, err
+		}
+		return stats, nil
+	}
+
+	var wrapped struct {
+		PGStats      []PGStat `json:"pg_stats"`
+		StuckPGStats []PGStat `json:"stuck_pg_stats"`
+		PGMap        struct {
+			PGStats []PGStat `json:"pg_stats"`
+		} `json:"pg_map"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, err
+	}
+	stats := append(wrapped.PGStats, wrapped.StuckPGStats...)
+	return append(stats, wrapped.PGMap.PGStats...), nil
+}
+
+// PoolID returns the pool a PG belongs to, the part of its ID before the dot.
+func PoolID(pgid string) (int, bool) {
+	pool, _, ok := strings.Cut(pgid, ".")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(pool)
+	return id, err == nil
+}
+
+// IsShardPGID reports whether pgid names one shard of an erasure-coded PG,
+// "<pool>.<pg>s<shard>". Each shard holds different data, so shards are not
+// copies of each other.
+func IsShardPGID(pgid string) bool {
+	_, pg, ok := strings.Cut(pgid, ".")
+	return ok && strings.Contains(pg, "s")
+}
+
+// Pool is an entry of `ceph osd lspools`.
+type Pool struct {
+	ID   int    `json:"poolnum"`
+	Name string `json:"poolname"`
+}
+
+// FSID is the output of `ceph fsid`.
+type FSID struct {
+	FSID string `json:"fsid"`
+}
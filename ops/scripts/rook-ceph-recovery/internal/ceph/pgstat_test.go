@@ -0,0 +1,120 @@
+package ceph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePGStats(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []string // PG IDs in order
+		wantErr bool
+	}{
+		{
+			name: "bare array",
+			in:   `[{"pgid":"1.1a","version":"120'45","state":"down"},{"pgid":"1.1b","version":"0'0","state":"incomplete"}]`,
+			want: []string{"1.1a", "1.1b"},
+		},
+		{
+			name: "pg ls",
+			in:   `{"pg_ready":true,"pg_stats":[{"pgid":"2.3","version":"5'7","state":"active+clean"}]}`,
+			want: []string{"2.3"},
+		},
+		{
+			name: "dump_stuck",
+			in:   `{"stuck_pg_stats":[{"pgid":"1.1a","version":"120'45","state":"down"}]}`,
+			want: []string{"1.1a"},
+		},
+		{
+			name: "pg dump",
+			in:   `{"pg_map":{"version":3,"pg_stats":[{"pgid":"1.0","version":"1'1","state":"active+clean"},{"pgid":"1.1","version":"1'2","state":"active+clean"}]}}`,
+			want: []string{"1.0", "1.1"},
+		},
+		{
+			name: "nothing stuck",
+			in:   `{}`,
+		},
+		{
+			name:    "bad eversion in array",
+			in:      `[{"pgid":"1.1a","version":"120"}]`,
+			wantErr: true,
+		},
+		{
+			name: "array after whitespace",
+			in:   "\n  [{\"pgid\":\"1.1a\",\"version\":\"120'45\"}]\n",
+			want: []string{"1.1a"},
+		},
+		{
+			// A bad field inside the wrapper is reported, not hidden by a
+			// retry as a bare array
+			name:    "bad eversion in wrapper",
+			in:      `{"pg_stats":[{"pgid":"1.1a","version":"120"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "not JSON",
+			in:      `ok`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := ParsePGStats([]byte(tt.in))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParsePGStats = %+v, want an error", stats)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, s := range stats {
+				got = append(got, s.PGID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PG IDs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePGStatsFields(t *testing.T) {
+	stats, err := ParsePGStats([]byte(`{"pg_stats":[{"pgid":"1.1a","version":"120'45","state":"down+peering",
+		"up":[3,2],"acting":[3,2],"up_primary":3,"acting_primary":3,
+		"stat_sum":{"num_bytes":4096,"num_objects":10,"num_objects_missing":1,"num_objects_degraded":2,"num_objects_unfound":3}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("got %d stats, want 1", len(stats))
+	}
+	s := stats[0]
+	if s.Version != (Eversion{Epoch: 120, Version: 45}) || s.State != "down+peering" {
+		t.Errorf("version %v state %q", s.Version, s.State)
+	}
+	if !reflect.DeepEqual(s.Up, []int{3, 2}) || !reflect.DeepEqual(s.Acting, []int{3, 2}) || s.UpPrimary != 3 || s.ActingPrimary != 3 {
+		t.Errorf("up %v acting %v primaries %d/%d", s.Up, s.Acting, s.UpPrimary, s.ActingPrimary)
+	}
+	if sum := s.StatSum; sum.NumBytes != 4096 || sum.NumObjects != 10 || sum.NumObjectsMissing != 1 || sum.NumObjectsDegraded != 2 || sum.NumObjectsUnfound != 3 {
+		t.Errorf("stat_sum %+v", sum)
+	}
+}
+
+func TestIsShardPGID(t *testing.T) {
+	for pgid, want := range map[string]bool{
+		"1.1a":   false,
+		"12.ff":  false,
+		"3.5s0":  true,
+		"3.1fs2": true,
+		"rbd":    false,
+	} {
+		if got := IsShardPGID(pgid); got != want {
+			t.Errorf("IsShardPGID(%q) = %v, want %v", pgid, got, want)
+		}
+	}
+}
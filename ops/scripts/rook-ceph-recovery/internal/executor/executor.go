@@ -0,0 +1,110 @@
+// Package executor runs the external commands the recovery tools depend on
+// (kubectl, the rook-ceph plugin, ceph-objectstore-tool via kubectl exec) so
+// that they can be swapped for recorded output when there is no live cluster.
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Executor runs a command and returns its standard output. Failures are
+// reported as *CommandError.
+type Executor interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// CommandError describes a command that could not be run or exited non-zero.
+type CommandError struct {
+	Command  string
+	ExitCode int // -1 if the command never exited (not found, killed, no fixture)
+	Stderr   string
+	Err      error
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Command, e.Err)
+	if e.ExitCode >= 0 {
+		msg = fmt.Sprintf("%s: exit code %d", e.Command, e.ExitCode)
+	}
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Command runs commands on the local machine.
+type Command struct{}
+
+func (Command) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return out, commandError(ctx, CommandLine(name, args...), err, nil)
+	}
+	return out, nil
+}
+
+// commandError wraps an error from os/exec. stderr is what the command wrote,
+// if it was captured separately rather than by Output.
+func commandError(ctx context.Context, cmdLine string, err error, stderr []byte) *CommandError {
+	cmdErr := &CommandError{
+		Command:  cmdLine,
+		ExitCode: -1,
+		Stderr:   strings.TrimSpace(string(stderr)),
+		Err:      err,
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if stderr == nil {
+			cmdErr.Stderr = strings.TrimSpace(string(exitErr.Stderr))
+		}
+		if exitErr.Exited() {
+			cmdErr.ExitCode = exitErr.ExitCode()
+		}
+	}
+	// A killed process only says "signal: killed"; report why it was killed
+	if ctxErr := ctx.Err(); ctxErr != nil && cmdErr.ExitCode < 0 {
+		cmdErr.Err = ctxErr
+	}
+	return cmdErr
+}
+
+// CommandLine renders a command the way fixtures are keyed.
+func CommandLine(name string, args ...string) string {
+	return strings.Join(append([]string{name}, args...), " ")
+}
+
+// KubeContext runs kubectl against a specific kubeconfig context instead of
+// the current one. Other commands are passed through unchanged. kubectl
+// refuses flags before a plugin name, so for the rook-ceph plugin the flag is
+// passed to the plugin instead.
+type KubeContext struct {
+	Executor Executor
+	Context  string
+}
+
+func (k KubeContext) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return k.Executor.Run(ctx, name, k.args(name, args)...)
+}
+
+func (k KubeContext) Stream(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	return Stream(ctx, k.Executor, name, k.args(name, args)...)
+}
+
+func (k KubeContext) args(name string, args []string) []string {
+	if name != "kubectl" || k.Context == "" {
+		return args
+	}
+	if len(args) > 0 && args[0] == "rook-ceph" {
+		return append([]string{args[0], "--context", k.Context}, args[1:]...)
+	}
+	return append([]string{"--context", k.Context}, args...)
+}
@@ -0,0 +1,218 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		out      string
+		exitCode int
+		stderr   string
+	}{
+		{name: "success", script: "echo out", out: "out\n"},
+		{name: "exit code", script: "echo partial; echo broken >&2; exit 3", out: "partial\n", exitCode: 3, stderr: "broken"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Command{}.Run(context.Background(), "sh", "-c", tt.script)
+			if string(out) != tt.out {
+				t.Errorf("output = %q, want %q", out, tt.out)
+			}
+			if tt.exitCode == 0 {
+				if err != nil {
+					t.Fatalf("Run: %v", err)
+				}
+				return
+			}
+
+			var cmdErr *CommandError
+			if !errors.As(err, &cmdErr) {
+				t.Fatalf("error %v is not a *CommandError", err)
+			}
+			if cmdErr.ExitCode != tt.exitCode || cmdErr.Stderr != tt.stderr {
+				t.Errorf("exit code %d stderr %q, want %d %q", cmdErr.ExitCode, cmdErr.Stderr, tt.exitCode, tt.stderr)
+			}
+			if want := "sh -c " + tt.script; cmdErr.Command != want {
+				t.Errorf("Command = %q, want %q", cmdErr.Command, want)
+			}
+		})
+	}
+}
+
+func TestCommandRunNotFound(t *testing.T) {
+	_, err := Command{}.Run(context.Background(), "no-such-command-for-executor-tests")
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("error %v is not a *CommandError", err)
+	}
+	if cmdErr.ExitCode != -1 {
+		t.Errorf("ExitCode = %d, want -1", cmdErr.ExitCode)
+	}
+}
+
+func TestCommandRunTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := Command{}.Run(ctx, "sleep", "5")
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("error %v is not a *CommandError", err)
+	}
+	// A killed command reports why it was killed, not "signal: killed"
+	if cmdErr.ExitCode != -1 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got exit code %d, error %v; want -1 and the deadline", cmdErr.ExitCode, err)
+	}
+}
+
+func TestCommandStream(t *testing.T) {
+	r, err := Command{}.Stream(context.Background(), "sh", "-c", "echo a; echo b; echo failed >&2; exit 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "a\nb\n" {
+		t.Errorf("streamed %q", out)
+	}
+
+	var cmdErr *CommandError
+	if err := r.Close(); !errors.As(err, &cmdErr) || cmdErr.ExitCode != 2 || cmdErr.Stderr != "failed" {
+		t.Errorf("Close = %v, want exit code 2 with the stderr", err)
+	}
+}
+
+func TestCommandErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  *CommandError
+		want string
+	}{
+		{&CommandError{Command: "kubectl get pods", ExitCode: 1, Stderr: "forbidden"}, "kubectl get pods: exit code 1: forbidden"},
+		{&CommandError{Command: "kubectl get pods", ExitCode: -1, Err: errNoFixture}, "kubectl get pods: no fixture recorded"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// recorder records the command lines it is asked to run.
+type recorder struct {
+	commands []string
+}
+
+func (r *recorder) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	r.commands = append(r.commands, CommandLine(name, args...))
+	return nil, nil
+}
+
+func TestKubeContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		context string
+		cmd     []string
+		want    string
+	}{
+		{"kubectl", "prod", []string{"kubectl", "-n", "rook-ceph", "get", "pods"}, "kubectl --context prod -n rook-ceph get pods"},
+		{"plugin", "prod", []string{"kubectl", "rook-ceph", "ceph", "pg", "1.1a", "query"}, "kubectl rook-ceph --context prod ceph pg 1.1a query"},
+		{"other command", "prod", []string{"ceph", "status"}, "ceph status"},
+		{"no context", "", []string{"kubectl", "get", "pods"}, "kubectl get pods"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			k := KubeContext{Executor: rec, Context: tt.context}
+			if _, err := k.Run(context.Background(), tt.cmd[0], tt.cmd[1:]...); err != nil {
+				t.Fatal(err)
+			}
+			// Streaming through an executor that cannot stream places the
+			// flag the same way
+			if _, err := k.Stream(context.Background(), tt.cmd[0], tt.cmd[1:]...); err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{tt.want, tt.want}; !reflect.DeepEqual(rec.commands, want) {
+				t.Errorf("ran %q, want %q", rec.commands, want)
+			}
+		})
+	}
+}
+
+func TestFixture(t *testing.T) {
+	f := NewFixture("testdata")
+	f.File("fsid.json", "kubectl", "rook-ceph", "ceph", "fsid")
+	f.File("missing.json", "kubectl", "rook-ceph", "ceph", "status")
+	f.Output("ok", "kubectl", "get", "pods")
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		cmd  []string
+		out  string
+		err  error // wrapped by the *CommandError, nil for success
+	}{
+		{name: "file", cmd: []string{"kubectl", "rook-ceph", "ceph", "fsid"}, out: "{\"fsid\":\"abc\"}\n"},
+		{name: "output", cmd: []string{"kubectl", "get", "pods"}, out: "ok"},
+		{name: "not recorded", cmd: []string{"kubectl", "get", "nodes"}, err: errNoFixture},
+		{name: "file missing", cmd: []string{"kubectl", "rook-ceph", "ceph", "status"}},
+		{name: "context done", ctx: canceled, cmd: []string{"kubectl", "get", "pods"}, err: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			wantErr := tt.out == ""
+
+			out, err := f.Run(ctx, tt.cmd[0], tt.cmd[1:]...)
+			checkFixtureResult(t, "Run", string(out), err, tt.out, wantErr, tt.err)
+
+			var streamed []byte
+			r, err := f.Stream(ctx, tt.cmd[0], tt.cmd[1:]...)
+			if err == nil {
+				streamed, _ = io.ReadAll(r)
+				err = r.Close()
+			}
+			checkFixtureResult(t, "Stream", string(streamed), err, tt.out, wantErr, tt.err)
+		})
+	}
+}
+
+func checkFixtureResult(t *testing.T, method, out string, err error, wantOut string, wantErr bool, wrapped error) {
+	t.Helper()
+	if !wantErr {
+		if err != nil || out != wantOut {
+			t.Errorf("%s = %q, %v; want %q", method, out, err, wantOut)
+		}
+		return
+	}
+
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.ExitCode != -1 {
+		t.Fatalf("%s error %v is not a *CommandError with exit code -1", method, err)
+	}
+	if wrapped != nil && !errors.Is(err, wrapped) {
+		t.Errorf("%s error %v does not wrap %v", method, err, wrapped)
+	}
+	if !strings.HasPrefix(cmdErr.Command, "kubectl ") {
+		t.Errorf("%s error names command %q", method, cmdErr.Command)
+	}
+}
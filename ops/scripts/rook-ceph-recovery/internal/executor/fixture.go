@@ -0,0 +1,89 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var errNoFixture = errors.New("no fixture recorded")
+
+type fixtureEntry struct {
+	file   string
+	output []byte
+}
+
+// Fixture replays previously captured command output instead of running
+// anything, so the tools can be pointed at an incident capture offline.
+// Commands may be registered while others are being replayed.
+type Fixture struct {
+	dir     string
+	mu      sync.RWMutex
+	entries map[string]fixtureEntry
+}
+
+func NewFixture(dir string) *Fixture {
+	return &Fixture{
+		dir:     dir,
+		entries: make(map[string]fixtureEntry),
+	}
+}
+
+// File replays the content of file (relative to the fixture directory)
+// whenever the given command is run.
+func (f *Fixture) File(file string, name string, args ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries[CommandLine(name, args...)] = fixtureEntry{file: file}
+}
+
+// Output replays output verbatim whenever the given command is run.
+func (f *Fixture) Output(output string, name string, args ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries[CommandLine(name, args...)] = fixtureEntry{output: []byte(output)}
+}
+
+func (f *Fixture) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	r, err := f.open(ctx, name, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &CommandError{Command: CommandLine(name, args...), ExitCode: -1, Err: err}
+	}
+	return out, nil
+}
+
+// open returns what is replayed for a command. Failures, a done context
+// included, are reported as *CommandError, as the live executors do.
+func (f *Fixture) open(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	cmdLine := CommandLine(name, args...)
+	if err := ctx.Err(); err != nil {
+		return nil, &CommandError{Command: cmdLine, ExitCode: -1, Err: err}
+	}
+
+	f.mu.RLock()
+	entry, ok := f.entries[cmdLine]
+	f.mu.RUnlock()
+	if !ok {
+		return nil, &CommandError{Command: cmdLine, ExitCode: -1, Err: errNoFixture}
+	}
+
+	if entry.file == "" {
+		return io.NopCloser(bytes.NewReader(entry.output)), nil
+	}
+
+	file, err := os.Open(filepath.Join(f.dir, entry.file))
+	if err != nil {
+		return nil, &CommandError{Command: cmdLine, ExitCode: -1, Err: err}
+	}
+	return file, nil
+}
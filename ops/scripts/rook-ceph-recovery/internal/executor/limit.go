@@ -0,0 +1,85 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limited bounds how many commands run at once and how long each may take,
+// so callers can fan out freely without flooding the API server or hanging
+// forever on a stuck kubectl exec.
+type Limited struct {
+	executor Executor
+	timeout  time.Duration
+	slots    chan struct{}
+}
+
+// NewLimited wraps ex so that at most concurrency commands run at a time and
+// each is cancelled after timeout. A zero timeout means no deadline.
+func NewLimited(ex Executor, concurrency int, timeout time.Duration) *Limited {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Limited{
+		executor: ex,
+		timeout:  timeout,
+		slots:    make(chan struct{}, concurrency),
+	}
+}
+
+func (l *Limited) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	ctx, release, err := l.acquire(ctx, name, args)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return l.executor.Run(ctx, name, args...)
+}
+
+// Stream streams through the wrapped executor. The command keeps its slot,
+// and its deadline applies, until the stream is closed.
+func (l *Limited) Stream(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	ctx, release, err := l.acquire(ctx, name, args)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Stream(ctx, l.executor, name, args...)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &limitedStream{ReadCloser: r, release: release}, nil
+}
+
+// acquire waits for a free slot and returns the context to run the command
+// in and the function that gives the slot back.
+func (l *Limited) acquire(ctx context.Context, name string, args []string) (context.Context, func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, &CommandError{Command: CommandLine(name, args...), ExitCode: -1, Err: ctx.Err()}
+	}
+
+	cancel := func() {}
+	if l.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+	}
+	return ctx, func() {
+		cancel()
+		<-l.slots
+	}, nil
+}
+
+type limitedStream struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (s *limitedStream) Close() error {
+	err := s.ReadCloser.Close()
+	s.once.Do(s.release)
+	return err
+}
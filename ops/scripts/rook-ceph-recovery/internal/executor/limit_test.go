@@ -0,0 +1,161 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blocking runs every command until its context is done or release is
+// closed, counting how many run at once.
+type blocking struct {
+	release chan struct{}
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (b *blocking) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	n := b.running.Add(1)
+	defer b.running.Add(-1)
+	for {
+		peak := b.peak.Load()
+		if n <= peak || b.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	select {
+	case <-b.release:
+		return []byte("done"), nil
+	case <-ctx.Done():
+		return nil, &CommandError{Command: CommandLine(name, args...), ExitCode: -1, Err: ctx.Err()}
+	}
+}
+
+func TestLimitedConcurrency(t *testing.T) {
+	b := &blocking{release: make(chan struct{})}
+	l := NewLimited(b, 2, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.Run(context.Background(), "kubectl", "get", "pods"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// Give every goroutine the chance to start more commands than allowed
+	time.Sleep(50 * time.Millisecond)
+	close(b.release)
+	wg.Wait()
+
+	if peak := b.peak.Load(); peak != 2 {
+		t.Errorf("%d commands ran at once, want 2", peak)
+	}
+}
+
+func TestLimitedTimeout(t *testing.T) {
+	l := NewLimited(&blocking{release: make(chan struct{})}, 1, 20*time.Millisecond)
+
+	_, err := l.Run(context.Background(), "kubectl", "get", "pods")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run = %v, want the deadline exceeded", err)
+	}
+
+	// The slot is given back once the command times out
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := l.Run(ctx, "kubectl", "get", "pods"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second Run = %v, want its own timeout rather than waiting for a slot", err)
+	}
+}
+
+func TestLimitedWaitingForSlotIsCancelled(t *testing.T) {
+	b := &blocking{release: make(chan struct{})}
+	defer close(b.release)
+	l := NewLimited(b, 1, 0)
+
+	go func() { _, _ = l.Run(context.Background(), "kubectl", "get", "pods") }()
+	for b.running.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := l.Run(ctx, "kubectl", "get", "nodes")
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Command != "kubectl get nodes" || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run = %v, want a *CommandError for the command that waited", err)
+	}
+}
+
+func TestLimitedStreamHoldsSlotUntilClose(t *testing.T) {
+	f := NewFixture("testdata")
+	f.Output("a\nb\n", "kubectl", "exec", "list")
+	f.Output("ok", "kubectl", "get", "pods")
+	l := NewLimited(f, 1, 0)
+
+	r, err := l.Stream(context.Background(), "kubectl", "exec", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.(*limitedStream); !ok {
+		t.Fatalf("Stream returned %T, want it streamed rather than buffered", r)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Run(ctx, "kubectl", "get", "pods"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run while streaming = %v, want it to wait for the slot", err)
+	}
+
+	if out, err := io.ReadAll(r); err != nil || string(out) != "a\nb\n" {
+		t.Fatalf("streamed %q, %v", out, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Closing twice gives the slot back once
+	_ = r.Close()
+
+	if out, err := l.Run(context.Background(), "kubectl", "get", "pods"); err != nil || string(out) != "ok" {
+		t.Errorf("Run after Close = %q, %v", out, err)
+	}
+	if len(l.slots) != 0 {
+		t.Errorf("%d slots still taken", len(l.slots))
+	}
+}
+
+func TestLimitedStreamTimeout(t *testing.T) {
+	l := NewLimited(Command{}, 1, 50*time.Millisecond)
+
+	r, err := l.Stream(context.Background(), "sh", "-c", "echo started; exec sleep 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The deadline covers the whole stream, not only starting it
+	out, _ := io.ReadAll(r)
+	if string(out) != "started\n" {
+		t.Errorf("streamed %q", out)
+	}
+	if err := r.Close(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close = %v, want the deadline exceeded", err)
+	}
+}
+
+func TestLimitedStreamFailure(t *testing.T) {
+	l := NewLimited(NewFixture("testdata"), 1, 0)
+
+	if _, err := l.Stream(context.Background(), "kubectl", "get", "nodes"); !errors.Is(err, errNoFixture) {
+		t.Fatalf("Stream = %v, want no fixture", err)
+	}
+	if len(l.slots) != 0 {
+		t.Errorf("a failed Stream kept its slot")
+	}
+}
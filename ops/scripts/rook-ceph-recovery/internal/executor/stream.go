@@ -0,0 +1,65 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+)
+
+// Streamer is implemented by executors that can hand over a command's output
+// while it is still running, for output too large to hold in memory.
+type Streamer interface {
+	Stream(ctx context.Context, name string, args ...string) (io.ReadCloser, error)
+}
+
+// Stream runs a command through ex and returns its standard output as it is
+// produced. Close waits for the command and reports failures as
+// *CommandError, as Run does. Executors that cannot stream have the output
+// buffered by Run instead.
+func Stream(ctx context.Context, ex Executor, name string, args ...string) (io.ReadCloser, error) {
+	if s, ok := ex.(Streamer); ok {
+		return s.Stream(ctx, name, args...)
+	}
+
+	out, err := ex.Run(ctx, name, args...)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(out)), nil
+}
+
+func (Command) Stream(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, commandError(ctx, CommandLine(name, args...), err, nil)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, commandError(ctx, CommandLine(name, args...), err, nil)
+	}
+	return &commandStream{ReadCloser: stdout, ctx: ctx, cmd: cmd, stderr: stderr}, nil
+}
+
+type commandStream struct {
+	io.ReadCloser
+	ctx    context.Context
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+// Close closes the pipe first, so a command the caller stopped reading from
+// gets SIGPIPE instead of blocking Wait forever.
+func (s *commandStream) Close() error {
+	_ = s.ReadCloser.Close()
+	if err := s.cmd.Wait(); err != nil {
+		return commandError(s.ctx, CommandLine(s.cmd.Args[0], s.cmd.Args[1:]...), err, s.stderr.Bytes())
+	}
+	return nil
+}
+
+func (f *Fixture) Stream(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	return f.open(ctx, name, args...)
+}
@@ -0,0 +1,28 @@
+package objectstore
+
+import (
+	"encoding/json"
+
+	"main/internal/ceph"
+)
+
+// Dump is the output of `ceph-objectstore-tool <object> dump`: the object's
+// object_info_t, decoded from its "_" xattr, and what the store reports.
+type Dump struct {
+	Info struct {
+		Version     ceph.Eversion `json:"version"`
+		UserVersion uint64        `json:"user_version"`
+		Size        int64         `json:"size"`
+		Mtime       string        `json:"mtime"`
+		LocalMtime  string        `json:"local_mtime"`
+	} `json:"info"`
+	Stat struct {
+		Size int64 `json:"size"`
+	} `json:"stat"`
+}
+
+func ParseDump(data []byte) (Dump, error) {
+	var d Dump
+	err := json.Unmarshal(data, &d)
+	return d, err
+}
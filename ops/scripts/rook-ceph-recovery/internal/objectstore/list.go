@@ -0,0 +1,95 @@
+// Package objectstore parses the output of ceph-objectstore-tool.
+package objectstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Object is a ghobject_t as printed by `ceph-objectstore-tool --op list`.
+type Object struct {
+	OID        string `json:"oid"`
+	Key        string `json:"key"`
+	SnapID     int64  `json:"snapid"`
+	Hash       uint32 `json:"hash"`
+	Max        int    `json:"max"`
+	Pool       int64  `json:"pool"`
+	Namespace  string `json:"namespace"`
+	ShardID    int    `json:"shard_id"`
+	Generation uint64 `json:"generation"`
+}
+
+const (
+	// NoSnap is the snapid of the head object (CEPH_NOSNAP as printed in JSON).
+	NoSnap = -2
+	// NoShard is the shard of objects in replicated pools. The tool omits
+	// shard_id from the JSON for those.
+	NoShard = -1
+	// NoGen is the generation of current objects. Older generations are kept
+	// by EC pools to roll back partial writes; the tool omits generation for
+	// current objects.
+	NoGen = math.MaxUint64
+)
+
+// ID identifies the object within its PG, as
+// [namespace/]oid[#key][@snapid][~generation]. Clones of the same oid differ
+// by snapid, so the oid alone is not enough. The shard is left out so that
+// the copies of an object on different OSDs share an ID.
+func (o Object) ID() string {
+	id := o.OID
+	if o.Namespace != "" {
+		id = o.Namespace + "/" + id
+	}
+	if o.Key != "" {
+		id += "#" + o.Key
+	}
+	if o.SnapID != NoSnap {
+		id += fmt.Sprintf("@%d", o.SnapID)
+	}
+	if o.Generation != NoGen {
+		id += fmt.Sprintf("~%d", o.Generation)
+	}
+	return id
+}
+
+// FullID identifies the object across the cluster, as
+// pool:ID()[:s<shard>]. Shards of an EC object are different objects on
+// disk, so they get different FullIDs.
+func (o Object) FullID() string {
+	id := fmt.Sprintf("%d:%s", o.Pool, o.ID())
+	if o.ShardID != NoShard {
+		id += fmt.Sprintf(":s%d", o.ShardID)
+	}
+	return id
+}
+
+// Entry is one line of `--op list` output: ["<pgid>",{<ghobject>}].
+type Entry struct {
+	PGID   string
+	Object Object
+	// Raw is the line as printed, which is also how ceph-objectstore-tool
+	// expects the object to be named in per-object operations.
+	Raw string
+}
+
+// ParseLine parses one line of `--op list` output. Lines for the PG metadata
+// object have an empty oid; callers usually skip those.
+func ParseLine(line string) (Entry, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return Entry{}, err
+	}
+	if len(fields) < 2 {
+		return Entry{}, fmt.Errorf("expected [pgid, object], got %d elements", len(fields))
+	}
+
+	entry := Entry{Raw: line, Object: Object{ShardID: NoShard, Generation: NoGen}}
+	if err := json.Unmarshal(fields[0], &entry.PGID); err != nil {
+		return Entry{}, fmt.Errorf("pgid: %v", err)
+	}
+	if err := json.Unmarshal(fields[1], &entry.Object); err != nil {
+		return Entry{}, fmt.Errorf("object: %v", err)
+	}
+	return entry, nil
+}
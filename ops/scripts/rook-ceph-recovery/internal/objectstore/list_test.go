@@ -0,0 +1,140 @@
+package objectstore
+
+import (
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		pgid    string
+		want    Object
+		wantErr bool
+	}{
+		{
+			name: "replicated head",
+			line: `["1.1a",{"oid":"rbd_data.1","key":"","snapid":-2,"hash":12,"max":0,"pool":1,"namespace":"","max":0}]`,
+			pgid: "1.1a",
+			want: Object{OID: "rbd_data.1", SnapID: NoSnap, Hash: 12, Pool: 1, ShardID: NoShard, Generation: NoGen},
+		},
+		{
+			name: "PG metadata",
+			line: `["1.1a",{"oid":"","key":"","snapid":-2,"hash":0,"max":0,"pool":1,"namespace":"","max":0}]`,
+			pgid: "1.1a",
+			want: Object{SnapID: NoSnap, Pool: 1, ShardID: NoShard, Generation: NoGen},
+		},
+		{
+			name: "EC shard with an old generation",
+			line: `["3.5s2",{"oid":"obj","key":"k","snapid":4,"hash":7,"max":0,"pool":3,"namespace":"ns","shard_id":2,"generation":9}]`,
+			pgid: "3.5s2",
+			want: Object{OID: "obj", Key: "k", SnapID: 4, Hash: 7, Pool: 3, Namespace: "ns", ShardID: 2, Generation: 9},
+		},
+		{
+			name:    "not JSON",
+			line:    `1.1a rbd_data.1`,
+			wantErr: true,
+		},
+		{
+			name:    "missing object",
+			line:    `["1.1a"]`,
+			wantErr: true,
+		},
+		{
+			name:    "pgid not a string",
+			line:    `[1,{"oid":"a"}]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := ParseLine(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseLine = %+v, want an error", entry)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.PGID != tt.pgid {
+				t.Errorf("PGID = %q, want %q", entry.PGID, tt.pgid)
+			}
+			if entry.Object != tt.want {
+				t.Errorf("Object = %+v, want %+v", entry.Object, tt.want)
+			}
+			if entry.Raw != tt.line {
+				t.Errorf("Raw = %q, want the line as given", entry.Raw)
+			}
+		})
+	}
+}
+
+func TestObjectIDs(t *testing.T) {
+	head := Object{OID: "rbd_data.1", SnapID: NoSnap, Pool: 1, ShardID: NoShard, Generation: NoGen}
+
+	tests := []struct {
+		name   string
+		object Object
+		id     string
+		fullID string
+	}{
+		{
+			name:   "head",
+			object: head,
+			id:     "rbd_data.1",
+			fullID: "1:rbd_data.1",
+		},
+		{
+			name:   "namespace and key",
+			object: Object{OID: "o", Key: "k", Namespace: "ns", SnapID: NoSnap, Pool: 2, ShardID: NoShard, Generation: NoGen},
+			id:     "ns/o#k",
+			fullID: "2:ns/o#k",
+		},
+		{
+			name:   "clone",
+			object: Object{OID: "rbd_data.1", SnapID: 4, Pool: 1, ShardID: NoShard, Generation: NoGen},
+			id:     "rbd_data.1@4",
+			fullID: "1:rbd_data.1@4",
+		},
+		{
+			name:   "snapdir",
+			object: Object{OID: "rbd_data.1", SnapID: -1, Pool: 1, ShardID: NoShard, Generation: NoGen},
+			id:     "rbd_data.1@-1",
+			fullID: "1:rbd_data.1@-1",
+		},
+		{
+			name:   "EC shard",
+			object: Object{OID: "obj", SnapID: NoSnap, Pool: 3, ShardID: 2, Generation: NoGen},
+			id:     "obj",
+			fullID: "3:obj:s2",
+		},
+		{
+			name:   "EC shard, old generation of a clone",
+			object: Object{OID: "obj", SnapID: 4, Pool: 3, ShardID: 0, Generation: 9},
+			id:     "obj@4~9",
+			fullID: "3:obj@4~9:s0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.object.ID(); got != tt.id {
+				t.Errorf("ID() = %q, want %q", got, tt.id)
+			}
+			if got := tt.object.FullID(); got != tt.fullID {
+				t.Errorf("FullID() = %q, want %q", got, tt.fullID)
+			}
+		})
+	}
+
+	// Copies of an object on different OSDs share an ID even when they are
+	// different shards.
+	shard := head
+	shard.ShardID = 1
+	if head.ID() != shard.ID() || head.FullID() == shard.FullID() {
+		t.Errorf("shards: ID %q vs %q, FullID %q vs %q", head.ID(), shard.ID(), head.FullID(), shard.FullID())
+	}
+}
@@ -0,0 +1,30 @@
+package rook
+
+import (
+	"fmt"
+
+	"main/internal/executor"
+)
+
+// ReplayMaintenance registers an OSD in healthy maintenance mode on a fixture
+// (normal deployment scaled down, maintenance pod Ready) and returns the name
+// of its maintenance pod, for replaying captures that did not record the
+// Kubernetes state.
+func ReplayMaintenance(fixture *executor.Fixture, ns string, osd int) string {
+	pod := fmt.Sprintf("%s-replay", MaintenanceDeploymentName(osd))
+
+	deployments := fmt.Sprintf(`{"items":[
+		{"metadata":{"name":%q},"spec":{"replicas":0},"status":{}},
+		{"metadata":{"name":%q},"spec":{"replicas":1},"status":{"availableReplicas":1,"readyReplicas":1}}
+	]}`, DeploymentName(osd), MaintenanceDeploymentName(osd))
+	cmd := DeploymentsCommand(ns, osd)
+	fixture.Output(deployments, cmd[0], cmd[1:]...)
+
+	pods := fmt.Sprintf(`{"items":[
+		{"metadata":{"name":%q},"status":{"phase":"Running","conditions":[{"type":"Ready","status":"True"}]}}
+	]}`, pod)
+	cmd = PodsCommand(ns, osd)
+	fixture.Output(pods, cmd[0], cmd[1:]...)
+
+	return pod
+}
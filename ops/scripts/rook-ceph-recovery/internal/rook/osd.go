@@ -0,0 +1,187 @@
+// Package rook finds the Rook OSD maintenance pods that ceph-objectstore-tool
+// has to be run in, the same way the rook-ceph.sh helpers do.
+package rook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"main/internal/executor"
+)
+
+// NotUsableError explains why an OSD cannot be queried offline.
+type NotUsableError struct {
+	OSD    int
+	Reason string
+}
+
+func (e *NotUsableError) Error() string {
+	return fmt.Sprintf("OSD %d not usable: %s", e.OSD, e.Reason)
+}
+
+type deploymentList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			Replicas *int `json:"replicas"`
+		} `json:"spec"`
+		Status struct {
+			AvailableReplicas int `json:"availableReplicas"`
+			ReadyReplicas     int `json:"readyReplicas"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name              string  `json:"name"`
+			DeletionTimestamp *string `json:"deletionTimestamp"`
+		} `json:"metadata"`
+		Status struct {
+			Phase      string `json:"phase"`
+			Conditions []struct {
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+func osdSelector(osd int) string {
+	return fmt.Sprintf("app=rook-ceph-osd,osd=%d", osd)
+}
+
+// DeploymentsCommand lists the normal and maintenance deployments of an OSD.
+func DeploymentsCommand(ns string, osd int) []string {
+	return []string{"kubectl", "get", "deploy", "-n", ns, "-l", osdSelector(osd), "-o", "json"}
+}
+
+// PodsCommand lists the pods of an OSD, normal and maintenance alike.
+func PodsCommand(ns string, osd int) []string {
+	return []string{"kubectl", "get", "pod", "-n", ns, "-l", osdSelector(osd), "-o", "json"}
+}
+
+func DeploymentName(osd int) string {
+	return fmt.Sprintf("rook-ceph-osd-%d", osd)
+}
+
+func MaintenanceDeploymentName(osd int) string {
+	return DeploymentName(osd) + "-maintenance"
+}
+
+// AllDeploymentsCommand lists the deployments of every OSD.
+func AllDeploymentsCommand(ns string) []string {
+	return []string{"kubectl", "get", "deploy", "-n", ns, "-l", "app=rook-ceph-osd", "-o", "json"}
+}
+
+var maintenanceDeploymentRegexp = regexp.MustCompile(`^rook-ceph-osd-([0-9]+)-maintenance$`)
+
+// MaintenanceOSDs returns the IDs of all OSDs that have a maintenance
+// deployment, sorted. Use FindMaintenancePod to check each is usable.
+func MaintenanceOSDs(ctx context.Context, ex executor.Executor, ns string) ([]int, error) {
+	cmd := AllDeploymentsCommand(ns)
+	out, err := ex.Run(ctx, cmd[0], cmd[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	var deployments deploymentList
+	if err := json.Unmarshal(out, &deployments); err != nil {
+		return nil, fmt.Errorf("parsing OSD deployments: %v", err)
+	}
+
+	var ids []int
+	for _, d := range deployments.Items {
+		if m := maintenanceDeploymentRegexp.FindStringSubmatch(d.Metadata.Name); m != nil {
+			id, _ := strconv.Atoi(m[1])
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// FindMaintenancePod returns the Ready maintenance pod of an OSD, after
+// checking that the normal OSD deployment is scaled to zero so the OSD's
+// data is not in use. Otherwise it returns a *NotUsableError saying why.
+func FindMaintenancePod(ctx context.Context, ex executor.Executor, ns string, osd int) (string, error) {
+	cmd := DeploymentsCommand(ns, osd)
+	out, err := ex.Run(ctx, cmd[0], cmd[1:]...)
+	if err != nil {
+		return "", err
+	}
+	var deployments deploymentList
+	if err := json.Unmarshal(out, &deployments); err != nil {
+		return "", fmt.Errorf("parsing deployments of OSD %d: %v", osd, err)
+	}
+
+	hasMaintenance := false
+	for _, d := range deployments.Items {
+		switch d.Metadata.Name {
+		case MaintenanceDeploymentName(osd):
+			hasMaintenance = true
+		case DeploymentName(osd):
+			replicas := 1
+			if d.Spec.Replicas != nil {
+				replicas = *d.Spec.Replicas
+			}
+			if replicas > 0 || d.Status.AvailableReplicas > 0 {
+				return "", &NotUsableError{OSD: osd, Reason: fmt.Sprintf("deployment %s is not scaled to zero (%d desired, %d available)",
+					d.Metadata.Name, replicas, d.Status.AvailableReplicas)}
+			}
+		}
+	}
+	if !hasMaintenance {
+		return "", &NotUsableError{OSD: osd, Reason: fmt.Sprintf("no %s deployment, run `kubectl rook-ceph maintenance start %s`",
+			MaintenanceDeploymentName(osd), DeploymentName(osd))}
+	}
+
+	cmd = PodsCommand(ns, osd)
+	out, err = ex.Run(ctx, cmd[0], cmd[1:]...)
+	if err != nil {
+		return "", err
+	}
+	var pods podList
+	if err := json.Unmarshal(out, &pods); err != nil {
+		return "", fmt.Errorf("parsing pods of OSD %d: %v", osd, err)
+	}
+
+	// Pods of the maintenance deployment are named after its replica set
+	prefix := MaintenanceDeploymentName(osd) + "-"
+	var ready, notReady []string
+	for _, p := range pods.Items {
+		if !strings.HasPrefix(p.Metadata.Name, prefix) || p.Metadata.DeletionTimestamp != nil {
+			continue
+		}
+
+		isReady := false
+		for _, c := range p.Status.Conditions {
+			if c.Type == "Ready" && c.Status == "True" {
+				isReady = true
+			}
+		}
+		if p.Status.Phase == "Running" && isReady {
+			ready = append(ready, p.Metadata.Name)
+		} else {
+			notReady = append(notReady, fmt.Sprintf("%s is %s, not Ready", p.Metadata.Name, p.Status.Phase))
+		}
+	}
+
+	if len(ready) == 0 {
+		reason := "no maintenance pod found"
+		if len(notReady) > 0 {
+			reason = strings.Join(notReady, "; ")
+		}
+		return "", &NotUsableError{OSD: osd, Reason: reason}
+	}
+
+	sort.Strings(ready)
+	return ready[0], nil
+}
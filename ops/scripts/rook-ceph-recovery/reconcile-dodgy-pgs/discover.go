@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"main/internal/ceph"
+	"main/internal/executor"
+)
+
+// discoveredStates are the `ceph pg ls` states that make a PG worth reconciling.
+var discoveredStates = []string{"incomplete", "down", "inconsistent"}
+
+// crushItemNone marks a missing shard in erasure-coded up/acting sets.
+const crushItemNone = 2147483647
+
+// Candidate is a PG found by discovery together with the OSDs that may hold a
+// copy of it.
+type Candidate struct {
+	PGID    string
+	Reasons []string
+	// Up and Acting are in Ceph's order: primary first, and by shard for
+	// erasure-coded PGs. OSDs merges them for the set of OSDs to query.
+	Up     []int
+	Acting []int
+	// Historical are OSDs outside up/acting that the PG query says were, or
+	// may have been, part of the PG: peers, probed OSDs and peering blockers.
+	Historical []int
+
+	query string
+}
+
+// OSDs returns up, acting and historical OSDs, sorted and without duplicates.
+func (c Candidate) OSDs() []int {
+	return mergeOSDs(c.Up, c.Acting, c.Historical)
+}
+
+func stuckCommand() []string {
+	return []string{"kubectl", "rook-ceph", "ceph", "pg", "dump_stuck", "inactive", "stale", "-f", "json"}
+}
+
+func healthDetailCommand() []string {
+	return []string{"kubectl", "rook-ceph", "ceph", "health", "detail", "-f", "json"}
+}
+
+func pgLsCommand(state string) []string {
+	return []string{"kubectl", "rook-ceph", "ceph", "pg", "ls", state, "-f", "json"}
+}
+
+var (
+	healthPGRegexp     = regexp.MustCompile(`^pg ([0-9]+\.[0-9a-f]+) `)
+	healthActingRegexp = regexp.MustCompile(`acting \[([0-9,]+)\]`)
+)
+
+// discoverer finds dodgy PGs through the rook-ceph plugin. Every output it
+// reads is saved next to the pg_*.json files so a discovery can be replayed.
+type discoverer struct {
+	ex   executor.Executor
+	save bool
+}
+
+func (d *discoverer) read(ctx context.Context, cmd []string, file string) ([]byte, error) {
+	out, err := run(ctx, d.ex, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if d.save {
+		saveJSON(file, string(out))
+	}
+	return out, nil
+}
+
+// discoverPGs returns the candidate PGs, sorted by PG ID, from stuck PGs,
+// PGs in discoveredStates and PGs named in health checks. Sources that cannot
+// be read are reported in problems, but discovery carries on with the rest.
+func (d *discoverer) discoverPGs(ctx context.Context) (map[string]*Candidate, []string) {
+	candidates := make(map[string]*Candidate)
+	var problems []string
+
+	add := func(stat ceph.PGStat, reason string) {
+		c, ok := candidates[stat.PGID]
+		if !ok {
+			c = &Candidate{PGID: stat.PGID}
+			candidates[stat.PGID] = c
+		}
+		c.Reasons = appendUnique(c.Reasons, reason)
+		if len(stat.Up) > 0 {
+			c.Up = stat.Up
+		}
+		if len(stat.Acting) > 0 {
+			c.Acting = stat.Acting
+		}
+	}
+
+	if out, err := d.read(ctx, stuckCommand(), "discover_dump_stuck.json"); err != nil {
+		problems = append(problems, fmt.Sprintf("dump_stuck UNAVAILABLE: %v", err))
+	} else if stats, err := ceph.ParsePGStats(out); err != nil {
+		problems = append(problems, fmt.Sprintf("dump_stuck: %v", err))
+	} else {
+		for _, stat := range stats {
+			add(stat, "stuck "+stat.State)
+		}
+	}
+
+	for _, state := range discoveredStates {
+		out, err := d.read(ctx, pgLsCommand(state), fmt.Sprintf("discover_pg_ls_%s.json", state))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("pg ls %s UNAVAILABLE: %v", state, err))
+			continue
+		}
+		stats, err := ceph.ParsePGStats(out)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("pg ls %s: %v", state, err))
+			continue
+		}
+		for _, stat := range stats {
+			add(stat, state)
+		}
+	}
+
+	out, err := d.read(ctx, healthDetailCommand(), "discover_health_detail.json")
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("health detail UNAVAILABLE: %v", err))
+		return candidates, problems
+	}
+
+	var health struct {
+		Checks map[string]struct {
+			Detail []struct {
+				Message string `json:"message"`
+			} `json:"detail"`
+		} `json:"checks"`
+	}
+	if err := json.Unmarshal(out, &health); err != nil {
+		problems = append(problems, fmt.Sprintf("health detail: %v", err))
+		return candidates, problems
+	}
+	checks := make([]string, 0, len(health.Checks))
+	for check := range health.Checks {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+	for _, check := range checks {
+		for _, entry := range health.Checks[check].Detail {
+			m := healthPGRegexp.FindStringSubmatch(entry.Message)
+			if m == nil {
+				continue
+			}
+			stat := ceph.PGStat{PGID: m[1]}
+			if acting := healthActingRegexp.FindStringSubmatch(entry.Message); acting != nil {
+				stat.Acting = parseOSDs(acting[1])
+			}
+			add(stat, check)
+		}
+	}
+
+	return candidates, problems
+}
+
+// resolveOSDs runs `ceph pg <id> query` for every candidate to find the OSDs
+// that may hold a copy beyond the current up and acting sets.
+func (d *discoverer) resolveOSDs(ctx context.Context, candidates map[string]*Candidate) []string {
+	var (
+		mu       sync.Mutex
+		problems []string
+		wg       sync.WaitGroup
+	)
+	for _, c := range candidates {
+		wg.Add(1)
+		go func(c *Candidate) {
+			defer wg.Done()
+			out, err := d.read(ctx, clusterQueryCommand(c.PGID), fmt.Sprintf("pg_%s_cluster.json", c.PGID))
+			if err == nil {
+				var q ceph.PGQuery
+				if err = json.Unmarshal(out, &q); err == nil {
+					c.query = string(out)
+					// The query is newer than the listings; keep its sets as
+					// Ceph orders them, primary first
+					if len(q.Up) > 0 {
+						c.Up = q.Up
+					}
+					if len(q.Acting) > 0 {
+						c.Acting = q.Acting
+					}
+					c.Historical = historicalOSDs(&q, mergeOSDs(c.Up, c.Acting))
+				}
+			}
+			if err != nil {
+				mu.Lock()
+				problems = append(problems, fmt.Sprintf("PG %s: query UNAVAILABLE, using up/acting only: %v", c.PGID, err))
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	sort.Strings(problems)
+	return problems
+}
+
+// historicalOSDs collects every OSD the PG query mentions that is not in current.
+func historicalOSDs(q *ceph.PGQuery, current []int) []int {
+	var ids []int
+	addPeer := func(peer string) {
+		if id, err := strconv.Atoi(peerOSD(peer)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	for _, peer := range q.PeerInfo {
+		addPeer(peer.Peer)
+	}
+	for _, state := range q.RecoveryState {
+		for _, peer := range state.ProbingOSDs {
+			addPeer(peer)
+		}
+		ids = append(ids, state.DownOSDsWeWouldProbe...)
+		for _, blocker := range state.PeeringBlockedBy {
+			ids = append(ids, blocker.OSD)
+		}
+		for _, unfound := range state.MightHaveUnfound {
+			addPeer(unfound.OSD)
+		}
+	}
+
+	isCurrent := make(map[int]bool)
+	for _, id := range current {
+		isCurrent[id] = true
+	}
+	var historical []int
+	for _, id := range mergeOSDs(ids) {
+		if !isCurrent[id] {
+			historical = append(historical, id)
+		}
+	}
+	return historical
+}
+
+// mergeOSDs returns the sorted union of OSD sets, without missing EC shards.
+func mergeOSDs(sets ...[]int) []int {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, set := range sets {
+		for _, id := range set {
+			if id < 0 || id == crushItemNone || seen[id] {
+				continue
+			}
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, existing := range list {
+		if existing == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func formatOSDs(ids []int) string {
+	var s []string
+	for _, id := range ids {
+		s = append(s, strconv.Itoa(id))
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"main/internal/executor"
+)
+
+func TestResolveOSDsKeepsCephOrder(t *testing.T) {
+	fixture := executor.NewFixture("testdata/diverged")
+	replayPGs(fixture, []string{"1.1a"})
+	d := &discoverer{ex: fixture}
+
+	// dump_stuck listed the sets in another order; the query's wins
+	c := &Candidate{PGID: "1.1a", Up: []int{2, 3}, Acting: []int{2, 3}}
+	if problems := d.resolveOSDs(context.Background(), map[string]*Candidate{c.PGID: c}); len(problems) > 0 {
+		t.Fatalf("problems: %q", problems)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		got, want []int
+	}{
+		{"up", c.Up, []int{3, 2}},
+		{"acting", c.Acting, []int{3, 2}},
+		{"historical", c.Historical, []int{4, 5}},
+		{"OSDs", c.OSDs(), []int{2, 3, 4, 5}},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"main/internal/ceph"
+	"main/internal/executor"
+	"main/internal/objectstore"
+)
+
+// ObjectComparison reports the objects of a PG that are not present on every
+// OSD that could be read, i.e. what would be lost by exporting a given copy.
+type ObjectComparison struct {
+	Sources   []ObjectSource   `json:"sources" yaml:"sources"`
+	Divergent []ObjectPresence `json:"divergent" yaml:"divergent"`
+}
+
+type ObjectSource struct {
+	Name      string `json:"name" yaml:"name"`
+	Available bool   `json:"available" yaml:"available"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+	Objects   int    `json:"objects" yaml:"objects"`
+	// LostIfChosen counts objects found on other OSDs but not on this one.
+	LostIfChosen int `json:"lost_if_chosen" yaml:"lost_if_chosen"`
+}
+
+type ObjectPresence struct {
+	Object    string   `json:"object" yaml:"object"`
+	PresentOn []string `json:"present_on" yaml:"present_on"`
+	MissingOn []string `json:"missing_on" yaml:"missing_on"`
+	// Versions maps each OSD the object is present on to its object_info_t
+	// version, when -object-versions was given and the dump succeeded.
+	Versions map[string]string `json:"versions,omitempty" yaml:"versions,omitempty"`
+}
+
+// objectListing is one OSD's `--op list` output for a PG, keyed by object ID.
+type objectListing struct {
+	name    string
+	osd     int
+	pod     string
+	entries map[string]objectstore.Entry
+	err     error
+}
+
+func listObjects(ctx context.Context, ex executor.Executor, ns, pod string, osd int, pgid string) (string, map[string]objectstore.Entry, error) {
+	out, err := run(ctx, ex, ceph.ListCommand(ns, pod, osd, pgid))
+	if err != nil {
+		return "", nil, err
+	}
+
+	entries := make(map[string]objectstore.Entry)
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		entry, err := objectstore.ParseLine(line)
+		if err != nil {
+			return "", nil, fmt.Errorf("parsing object list of OSD %d: %v", osd, err)
+		}
+		if entry.Object.OID == "" || entry.PGID != pgid {
+			continue // PG metadata object
+		}
+		entries[entry.Object.ID()] = entry
+	}
+	return string(out), entries, nil
+}
+
+// objectVersion reads the object_info_t version of one object on one OSD.
+func objectVersion(ctx context.Context, ex executor.Executor, ns, pod string, osd int, pgid string, entry objectstore.Entry) (string, error) {
+	out, err := run(ctx, ex, ceph.DumpCommand(ns, pod, osd, pgid, entry.Raw))
+	if err != nil {
+		return "", err
+	}
+
+	dump, err := objectstore.ParseDump(out)
+	if err != nil {
+		return "", fmt.Errorf("parsing dump of %s on OSD %d: %v", entry.Object.ID(), osd, err)
+	}
+	return dump.Info.Version.String(), nil
+}
+
+func compareObjects(listings []objectListing) ObjectComparison {
+	var oc ObjectComparison
+
+	all := make(map[string]bool)
+	for _, l := range listings {
+		for id := range l.entries {
+			all[id] = true
+		}
+	}
+
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	lost := make(map[string]int)
+	for _, id := range ids {
+		presence := ObjectPresence{Object: id}
+		for _, l := range listings {
+			if l.err != nil {
+				continue
+			}
+			if _, ok := l.entries[id]; ok {
+				presence.PresentOn = append(presence.PresentOn, l.name)
+			} else {
+				presence.MissingOn = append(presence.MissingOn, l.name)
+				lost[l.name]++
+			}
+		}
+		if len(presence.MissingOn) > 0 {
+			oc.Divergent = append(oc.Divergent, presence)
+		}
+	}
+
+	for _, l := range listings {
+		src := ObjectSource{Name: l.name, Available: l.err == nil, Objects: len(l.entries), LostIfChosen: lost[l.name]}
+		if l.err != nil {
+			src.Error = l.err.Error()
+		}
+		oc.Sources = append(oc.Sources, src)
+	}
+
+	return oc
+}
+
+// addObjectVersions fills in per-OSD versions for the divergent objects only,
+// since dumping every object of a large PG over kubectl exec takes too long.
+func addObjectVersions(ctx context.Context, ex executor.Executor, ns, pgid string, oc *ObjectComparison, listings []objectListing) []error {
+	var errs []error
+	for i := range oc.Divergent {
+		presence := &oc.Divergent[i]
+		for _, l := range listings {
+			entry, ok := l.entries[presence.Object]
+			if !ok {
+				continue
+			}
+			version, err := objectVersion(ctx, ex, ns, l.pod, l.osd, pgid, entry)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if presence.Versions == nil {
+				presence.Versions = make(map[string]string)
+			}
+			presence.Versions[l.name] = version
+		}
+	}
+	return errs
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+
+	"main/internal/ceph"
+)
+
+var outputFormats = []string{"table", "json", "yaml", "markdown", "csv"}
+
+const unavailable = "UNAVAILABLE"
+
+// comparedFields are the PGInfo fields shown side by side for every source.
+var comparedFields = []struct {
+	name string
+	get  func(ceph.PGInfo) string
+}{
+	{"last_update", func(i ceph.PGInfo) string { return i.LastUpdate.String() }},
+	{"last_complete", func(i ceph.PGInfo) string { return i.LastComplete.String() }},
+	{"last_user_version", func(i ceph.PGInfo) string { return strconv.Itoa(i.LastUserVersion) }},
+	{"num_objects", func(i ceph.PGInfo) string { return strconv.Itoa(i.Stats.StatSum.NumObjects) }},
+	{"stats.version", func(i ceph.PGInfo) string { return i.Stats.Version.String() }},
+}
+
+// Comparison is everything reported about one PG, independent of the output
+// format it is rendered in.
+type Comparison struct {
+	PGID           string         `json:"pgid" yaml:"pgid"`
+	Sources        []SourceStatus `json:"sources" yaml:"sources"`
+	Fields         []FieldRow     `json:"fields" yaml:"fields"`
+	MostRecent     string         `json:"most_recent" yaml:"most_recent"`
+	Recommendation Recommendation `json:"recommendation" yaml:"recommendation"`
+	// Objects is only set when object listings were compared (-objects).
+	Objects *ObjectComparison `json:"objects,omitempty" yaml:"objects,omitempty"`
+
+	raw map[string]string
+}
+
+type SourceStatus struct {
+	Name      string `json:"name" yaml:"name"`
+	Available bool   `json:"available" yaml:"available"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// FieldRow is one field across all sources, in source order. Consensus is the
+// value held by a strict majority of the readable sources; when there is no
+// such value Majority is false and no source is singled out as an outlier.
+type FieldRow struct {
+	Field     string       `json:"field" yaml:"field"`
+	Consensus string       `json:"consensus,omitempty" yaml:"consensus,omitempty"`
+	Majority  bool         `json:"majority" yaml:"majority"`
+	Values    []FieldValue `json:"values" yaml:"values"`
+}
+
+type FieldValue struct {
+	Source    string `json:"source" yaml:"source"`
+	Value     string `json:"value,omitempty" yaml:"value,omitempty"`
+	Available bool   `json:"available" yaml:"available"`
+	Outlier   bool   `json:"outlier" yaml:"outlier"`
+}
+
+func (r FieldRow) status(v FieldValue) string {
+	switch {
+	case !v.Available:
+		return "unavailable"
+	case !r.Majority:
+		return "no_majority"
+	case v.Outlier:
+		return "outlier"
+	}
+	return "ok"
+}
+
+func (r FieldRow) consensusLabel() string {
+	if !r.Majority {
+		return "NO MAJORITY"
+	}
+	return r.Consensus
+}
+
+func compare(pgid string, query *ceph.PGQuery, sources []source) Comparison {
+	c := Comparison{
+		PGID:           pgid,
+		MostRecent:     findMostRecent(sources),
+		Recommendation: recommend(pgid, query, sources),
+		raw:            make(map[string]string),
+	}
+
+	for _, src := range sources {
+		status := SourceStatus{Name: src.name, Available: src.err == nil}
+		if src.err != nil {
+			status.Error = src.err.Error()
+		}
+		c.Sources = append(c.Sources, status)
+		c.raw[src.name] = src.raw
+	}
+
+	for _, f := range comparedFields {
+		row := FieldRow{Field: f.name}
+		counts := make(map[string]int)
+		readable := 0
+		for _, src := range sources {
+			if src.err != nil {
+				row.Values = append(row.Values, FieldValue{Source: src.name})
+				continue
+			}
+			v := f.get(src.info)
+			counts[v]++
+			readable++
+			row.Values = append(row.Values, FieldValue{Source: src.name, Value: v, Available: true})
+		}
+
+		for v, n := range counts {
+			if n*2 > readable {
+				row.Consensus = v
+				row.Majority = true
+			}
+		}
+		if row.Majority {
+			for i := range row.Values {
+				row.Values[i].Outlier = row.Values[i].Available && row.Values[i].Value != row.Consensus
+			}
+		}
+		c.Fields = append(c.Fields, row)
+	}
+
+	return c
+}
+
+// reportWriter renders comparisons in one output format. Formats that need
+// the whole document (json, yaml) buffer until Close.
+type reportWriter interface {
+	WritePG(c Comparison) error
+	Close() error
+}
+
+func newReportWriter(format string, w io.Writer, color bool) (reportWriter, error) {
+	switch format {
+	case "table":
+		return &tableWriter{w: w, color: color}, nil
+	case "json":
+		return &documentWriter{w: w, encode: func(w io.Writer, v interface{}) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(v)
+		}}, nil
+	case "yaml":
+		return &documentWriter{w: w, encode: func(w io.Writer, v interface{}) error {
+			enc := yaml.NewEncoder(w)
+			enc.SetIndent(2)
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+			return enc.Close()
+		}}, nil
+	case "markdown":
+		return &markdownWriter{w: w}, nil
+	case "csv":
+		return newCSVWriter(w), nil
+	}
+	return nil, fmt.Errorf("unknown output format %q (want one of %s)", format, strings.Join(outputFormats, ", "))
+}
+
+// ANSI colours used by the table. They are all the same length so that
+// tabwriter, which counts bytes, still lines the columns up.
+const (
+	ansiDefault = "\x1b[39m"
+	ansiRed     = "\x1b[31m"
+	ansiYellow  = "\x1b[33m"
+	ansiGreen   = "\x1b[32m"
+	ansiReset   = "\x1b[0m"
+)
+
+type tableWriter struct {
+	w     io.Writer
+	color bool
+}
+
+func (t *tableWriter) cell(buf *bytes.Buffer, text, color string) {
+	if t.color {
+		buf.WriteString(color + text + ansiReset + "\t")
+		return
+	}
+	buf.WriteString(text + "\t")
+}
+
+func (t *tableWriter) WritePG(c Comparison) error {
+	var buf bytes.Buffer
+	t.cell(&buf, "Field", ansiDefault)
+	for _, src := range c.Sources {
+		t.cell(&buf, src.Name, ansiDefault)
+	}
+	t.cell(&buf, "consensus", ansiDefault)
+	buf.WriteString("\n")
+
+	for _, row := range c.Fields {
+		t.cell(&buf, row.Field, ansiDefault)
+		for _, v := range row.Values {
+			switch {
+			case !v.Available:
+				t.cell(&buf, unavailable, ansiYellow)
+			case v.Outlier:
+				t.cell(&buf, "*"+v.Value, ansiRed) // Highlight outlier
+			default:
+				t.cell(&buf, v.Value, ansiDefault)
+			}
+		}
+		if row.Majority {
+			t.cell(&buf, row.consensusLabel(), ansiGreen)
+		} else {
+			t.cell(&buf, row.consensusLabel(), ansiYellow)
+		}
+		buf.WriteString("\n")
+	}
+
+	w := tabwriter.NewWriter(t.w, 1, 1, 1, ' ', 0)
+	_, _ = fmt.Fprintln(w, buf.String())
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(t.w, "Most up-to-date: %s\n", mostRecentLabel(c.MostRecent))
+
+	rec := c.Recommendation
+	_, _ = fmt.Fprintf(t.w, "Recommendation for PG %s:\n", rec.PGID)
+	if len(rec.Ranking) == 0 {
+		_, _ = fmt.Fprintln(t.w, "  no OSD copy could be scored")
+	}
+	for i, r := range rec.Ranking {
+		_, _ = fmt.Fprintf(t.w, "  %d. %s (score %d, from %s)\n", i+1, r.Name, r.Score, r.Origin)
+		for _, reason := range r.Reasons {
+			_, _ = fmt.Fprintf(t.w, "       %s\n", reason)
+		}
+	}
+	for _, excluded := range rec.Excluded {
+		_, _ = fmt.Fprintf(t.w, "  excluded: %s\n", excluded)
+	}
+	for _, warning := range rec.Warnings {
+		_, _ = fmt.Fprintf(t.w, "  WARNING: %s\n", warning)
+	}
+
+	if c.Objects != nil {
+		t.writeObjects(c.PGID, c.Objects)
+	}
+	return nil
+}
+
+func (t *tableWriter) writeObjects(pgid string, oc *ObjectComparison) {
+	_, _ = fmt.Fprintf(t.w, "Objects in PG %s:\n", pgid)
+	for _, src := range oc.Sources {
+		if !src.Available {
+			_, _ = fmt.Fprintf(t.w, "  %s: %s\n", src.Name, unavailable)
+			continue
+		}
+		_, _ = fmt.Fprintf(t.w, "  %s: %d objects, %d lost if chosen\n", src.Name, src.Objects, src.LostIfChosen)
+	}
+	if len(oc.Divergent) == 0 {
+		_, _ = fmt.Fprintln(t.w, "  all readable OSDs hold the same objects")
+		return
+	}
+
+	w := tabwriter.NewWriter(t.w, 1, 1, 1, ' ', 0)
+	_, _ = fmt.Fprintln(w, "  Object\tPresent on\tMissing on\tVersions\t")
+	for _, p := range oc.Divergent {
+		_, _ = fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t\n", p.Object, strings.Join(p.PresentOn, ","), strings.Join(p.MissingOn, ","), versionsLabel(p))
+	}
+	_ = w.Flush()
+}
+
+func versionsLabel(p ObjectPresence) string {
+	var versions []string
+	for _, name := range p.PresentOn {
+		if v, ok := p.Versions[name]; ok {
+			versions = append(versions, name+"="+v)
+		}
+	}
+	return strings.Join(versions, ",")
+}
+
+func (t *tableWriter) Close() error {
+	return nil
+}
+
+func mostRecentLabel(name string) string {
+	if name == "" {
+		return "none (no source could be read)"
+	}
+	return name
+}
+
+// documentWriter collects every PG and encodes them as one document.
+type documentWriter struct {
+	w      io.Writer
+	encode func(io.Writer, interface{}) error
+	pgs    []Comparison
+}
+
+func (d *documentWriter) WritePG(c Comparison) error {
+	d.pgs = append(d.pgs, c)
+	return nil
+}
+
+func (d *documentWriter) Close() error {
+	return d.encode(d.w, struct {
+		PGs []Comparison `json:"pgs" yaml:"pgs"`
+	}{d.pgs})
+}
+
+// csvWriter emits one row per PG, field and source, plus verdict rows, so the
+// output stays rectangular however many sources each PG has.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	cw := &csvWriter{w: csv.NewWriter(w)}
+	_ = cw.w.Write([]string{"pgid", "field", "source", "value", "status"})
+	return cw
+}
+
+func (c *csvWriter) WritePG(cmp Comparison) error {
+	for _, row := range cmp.Fields {
+		for _, v := range row.Values {
+			if err := c.w.Write([]string{cmp.PGID, row.Field, v.Source, v.Value, row.status(v)}); err != nil {
+				return err
+			}
+		}
+		if err := c.w.Write([]string{cmp.PGID, row.Field, "consensus", row.Consensus, row.status(FieldValue{Available: true})}); err != nil {
+			return err
+		}
+	}
+
+	if cmp.Objects != nil {
+		for _, p := range cmp.Objects.Divergent {
+			for _, name := range p.PresentOn {
+				if err := c.w.Write([]string{cmp.PGID, "object:" + p.Object, name, p.Versions[name], "present"}); err != nil {
+					return err
+				}
+			}
+			for _, name := range p.MissingOn {
+				if err := c.w.Write([]string{cmp.PGID, "object:" + p.Object, name, "", "missing"}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	recommended := ""
+	if len(cmp.Recommendation.Ranking) > 0 {
+		recommended = cmp.Recommendation.Ranking[0].Name
+	}
+	if err := c.w.Write([]string{cmp.PGID, "verdict.most_recent", "", cmp.MostRecent, ""}); err != nil {
+		return err
+	}
+	return c.w.Write([]string{cmp.PGID, "verdict.recommended", "", recommended, ""})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// markdownWriter produces the incident notes section for each PG: the
+// comparison and recommendation, the raw info captured before import, and
+// empty sections to paste the import and post-import output into.
+type markdownWriter struct {
+	w io.Writer
+}
+
+func (m *markdownWriter) WritePG(c Comparison) error {
+	var buf bytes.Buffer
+	osds := osdsLabel(c.Sources)
+	heading := c.PGID
+	if osds != "" {
+		heading += " - " + osds
+	}
+	fmt.Fprintf(&buf, "# %s\n\n## Comparison before import\n\n", heading)
+
+	buf.WriteString("| Field |")
+	for _, src := range c.Sources {
+		fmt.Fprintf(&buf, " %s |", src.Name)
+	}
+	buf.WriteString(" consensus |\n|---|")
+	for range c.Sources {
+		buf.WriteString("---|")
+	}
+	buf.WriteString("---|\n")
+	for _, row := range c.Fields {
+		fmt.Fprintf(&buf, "| %s |", row.Field)
+		for _, v := range row.Values {
+			cell := v.Value
+			switch {
+			case !v.Available:
+				cell = unavailable
+			case v.Outlier:
+				cell = "**" + v.Value + "**"
+			}
+			fmt.Fprintf(&buf, " %s |", cell)
+		}
+		consensus := row.consensusLabel()
+		if !row.Majority {
+			consensus = "**" + consensus + "**"
+		}
+		fmt.Fprintf(&buf, " %s |\n", consensus)
+	}
+	buf.WriteString("\nOutliers against the majority value are in **bold**.\n")
+
+	fmt.Fprintf(&buf, "\nMost up-to-date: %s\n\n## Recommendation\n\n", mostRecentLabel(c.MostRecent))
+	if len(c.Recommendation.Ranking) == 0 {
+		buf.WriteString("No OSD copy could be scored.\n")
+	}
+	for i, r := range c.Recommendation.Ranking {
+		fmt.Fprintf(&buf, "%d. **%s** (score %d, from %s)\n", i+1, r.Name, r.Score, r.Origin)
+		for _, reason := range r.Reasons {
+			fmt.Fprintf(&buf, "   - %s\n", reason)
+		}
+	}
+	for _, excluded := range c.Recommendation.Excluded {
+		fmt.Fprintf(&buf, "\n- Excluded: %s", excluded)
+	}
+	for _, warning := range c.Recommendation.Warnings {
+		fmt.Fprintf(&buf, "\n- **Warning:** %s", warning)
+	}
+	buf.WriteString("\n")
+
+	if c.Objects != nil {
+		writeMarkdownObjects(&buf, c.Objects)
+	}
+
+	for _, src := range c.Sources {
+		title := "Cluster info before import"
+		if src.Name != "cluster" {
+			title = fmt.Sprintf("OSD %s info before import", strings.TrimPrefix(src.Name, "osd"))
+		}
+		fmt.Fprintf(&buf, "\n## %s\n\n```json\n", title)
+		if src.Available {
+			buf.WriteString(strings.TrimRight(c.raw[src.Name], "\n"))
+		} else {
+			fmt.Fprintf(&buf, "Error: %s", src.Error)
+		}
+		buf.WriteString("\n```\n")
+	}
+
+	buf.WriteString("\n## Import output\n\n```bash\n\n```\n")
+	if osds == "" {
+		osds = "OSD"
+	}
+	fmt.Fprintf(&buf, "\n## %s info after import\n\n```bash\n\n```\n", osds)
+	buf.WriteString("\n## Cluster info after import\n\n```bash\n\n```\n\n")
+
+	_, err := m.w.Write(buf.Bytes())
+	return err
+}
+
+func (m *markdownWriter) Close() error {
+	return nil
+}
+
+// osdsLabel names the OSDs compared, such as "OSD 3" or "OSDs 2, 3", for the
+// headings of the import log template.
+func osdsLabel(sources []SourceStatus) string {
+	var ids []string
+	for _, src := range sources {
+		if src.Name != "cluster" {
+			ids = append(ids, strings.TrimPrefix(src.Name, "osd"))
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return ""
+	case 1:
+		return "OSD " + ids[0]
+	}
+	return "OSDs " + strings.Join(ids, ", ")
+}
+
+func writeMarkdownObjects(buf *bytes.Buffer, oc *ObjectComparison) {
+	buf.WriteString("\n## Object listing comparison\n\n| OSD | Objects | Lost if chosen |\n|---|---|---|\n")
+	for _, src := range oc.Sources {
+		if !src.Available {
+			fmt.Fprintf(buf, "| %s | %s | |\n", src.Name, unavailable)
+			continue
+		}
+		fmt.Fprintf(buf, "| %s | %d | %d |\n", src.Name, src.Objects, src.LostIfChosen)
+	}
+
+	if len(oc.Divergent) == 0 {
+		buf.WriteString("\nAll readable OSDs hold the same objects.\n")
+		return
+	}
+
+	buf.WriteString("\n| Object | Present on | Missing on | Versions |\n|---|---|---|---|\n")
+	for _, p := range oc.Divergent {
+		fmt.Fprintf(buf, "| `%s` | %s | %s | %s |\n", p.Object, strings.Join(p.PresentOn, ", "), strings.Join(p.MissingOn, ", "), versionsLabel(p))
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"main/internal/ceph"
+)
+
+// Score weights for recommend. Recency of the log counts most, then whether
+// that log is actually complete. Copies in a disqualifying state are ranked
+// after all the others whatever they score.
+const (
+	scoreNewestUpdate     = 100
+	scoreLogComplete      = 40
+	scoreLogIncomplete    = -40
+	scoreBackfilling      = -60
+	scoreNewestStarted    = 20
+	scoreNewestInterval   = 10
+	scoreMissingPerObject = -1
+	scoreMissingMax       = -30
+	scoreMostObjects      = 5
+	scoreLongestLog       = 5
+	scoreDisqualified     = -200
+	lastBackfillComplete  = "MAX"
+	peerInfoOrigin        = "cluster peer_info"
+	onDiskOrigin          = "on-disk info"
+	primaryInfoOrigin     = "cluster primary info"
+)
+
+// candidate is one OSD's copy of a PG, as seen on disk or by the primary.
+type candidate struct {
+	name        string
+	info        ceph.PGInfo
+	origin      string
+	backfilling bool
+}
+
+// Ranked is a candidate with its score and the reasons behind it.
+type Ranked struct {
+	Name    string   `json:"name" yaml:"name"`
+	Origin  string   `json:"origin" yaml:"origin"`
+	Score   int      `json:"score" yaml:"score"`
+	Reasons []string `json:"reasons" yaml:"reasons"`
+
+	disqualified bool
+}
+
+// Recommendation ranks the OSD copies of a PG from most to least suitable as
+// the authoritative copy to export.
+type Recommendation struct {
+	PGID     string   `json:"pgid" yaml:"pgid"`
+	Ranking  []Ranked `json:"ranking" yaml:"ranking"`
+	Excluded []string `json:"excluded,omitempty" yaml:"excluded,omitempty"`
+	Warnings []string `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+}
+
+// recommend scores every OSD source of a PG. query is the parsed
+// `ceph pg <id> query` output, or nil if it could not be read. OSD sources
+// whose on-disk info is unavailable fall back to the primary's peer_info.
+func recommend(pgid string, query *ceph.PGQuery, sources []source) Recommendation {
+	rec := Recommendation{PGID: pgid}
+
+	backfillTargets := map[string]bool{}
+	if query != nil {
+		rec.Warnings = append(rec.Warnings, recoveryStateWarnings(query)...)
+		for _, state := range query.RecoveryState {
+			for _, target := range state.RecoveryProgress.BackfillTargets {
+				backfillTargets[peerOSD(target)] = true
+			}
+		}
+	}
+
+	var candidates []candidate
+	for _, src := range sources {
+		if src.osd < 0 {
+			continue
+		}
+
+		osd := strconv.Itoa(src.osd)
+		clusterView, hasClusterView := clusterInfoFor(query, osd)
+
+		c := candidate{name: src.name, backfilling: backfillTargets[osd]}
+		switch {
+		case src.err == nil:
+			c.info = src.info
+			c.origin = onDiskOrigin
+			if hasClusterView && clusterView.LastUpdate != src.info.LastUpdate {
+				rec.Warnings = append(rec.Warnings, fmt.Sprintf("%s: cluster reports last_update %s but on-disk info has %s",
+					src.name, clusterView.LastUpdate, src.info.LastUpdate))
+			}
+		case hasClusterView:
+			c.info = clusterView.PGInfo
+			c.origin = clusterView.Origin
+		default:
+			rec.Excluded = append(rec.Excluded, fmt.Sprintf("%s: no on-disk info and not known to the cluster (%v)", src.name, src.err))
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+
+	rec.Ranking = rankCandidates(candidates)
+	rec.Warnings = append(rec.Warnings, divergenceWarnings(candidates)...)
+
+	if len(rec.Ranking) > 1 && rec.Ranking[0].Score == rec.Ranking[1].Score && !rec.Ranking[1].disqualified {
+		rec.Warnings = append(rec.Warnings, fmt.Sprintf("%s and %s are tied; pick by hand",
+			rec.Ranking[0].Name, rec.Ranking[1].Name))
+	}
+
+	return rec
+}
+
+// clusterPeerInfo is a peer_info entry together with where it came from.
+type clusterPeerInfo struct {
+	ceph.PGInfo
+	Origin string
+}
+
+// clusterInfoFor finds what the cluster knows about osd's copy: the top-level
+// info if osd is the acting primary, otherwise its peer_info entry.
+func clusterInfoFor(query *ceph.PGQuery, osd string) (clusterPeerInfo, bool) {
+	if query == nil {
+		return clusterPeerInfo{}, false
+	}
+	if len(query.Acting) > 0 && strconv.Itoa(query.Acting[0]) == osd {
+		return clusterPeerInfo{PGInfo: query.Info, Origin: primaryInfoOrigin}, true
+	}
+	for _, peer := range query.PeerInfo {
+		if peerOSD(peer.Peer) == osd {
+			return clusterPeerInfo{PGInfo: peer.PGInfo, Origin: peerInfoOrigin}, true
+		}
+	}
+	return clusterPeerInfo{}, false
+}
+
+// peerOSD strips the erasure-coded shard suffix from a peer such as "2(1)".
+func peerOSD(peer string) string {
+	if i := strings.IndexByte(peer, '('); i >= 0 {
+		return peer[:i]
+	}
+	return peer
+}
+
+func rankCandidates(candidates []candidate) []Ranked {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	newestUpdate := candidates[0].info.LastUpdate
+	oldestTail := candidates[0].info.LogTail
+	newestStarted, newestInterval, mostObjects := 0, 0, 0
+	for _, c := range candidates {
+		if newestUpdate.Less(c.info.LastUpdate) {
+			newestUpdate = c.info.LastUpdate
+		}
+		if c.info.LogTail.Less(oldestTail) {
+			oldestTail = c.info.LogTail
+		}
+		newestStarted = max(newestStarted, c.info.StartedEpoch())
+		newestInterval = max(newestInterval, c.info.History.SameIntervalSince)
+		mostObjects = max(mostObjects, c.info.Stats.StatSum.NumObjects)
+	}
+
+	var ranking []Ranked
+	for _, c := range candidates {
+		r := Ranked{Name: c.name, Origin: c.origin}
+		add := func(points int, format string, args ...interface{}) {
+			r.Score += points
+			r.Reasons = append(r.Reasons, fmt.Sprintf("%+d ", points)+fmt.Sprintf(format, args...))
+		}
+
+		info := c.info
+		if info.Incomplete != 0 {
+			add(scoreDisqualified, "marked incomplete")
+		}
+		if info.DNE != 0 {
+			add(scoreDisqualified, "PG does not exist here (dne)")
+		}
+		if info.Empty != 0 {
+			add(scoreDisqualified, "PG is empty")
+		}
+		r.disqualified = info.Incomplete != 0 || info.DNE != 0 || info.Empty != 0
+
+		if info.LastUpdate == newestUpdate {
+			add(scoreNewestUpdate, "newest last_update %s", info.LastUpdate)
+		} else {
+			add(0, "last_update %s behind newest %s", info.LastUpdate, newestUpdate)
+		}
+
+		if info.LastComplete == info.LastUpdate {
+			add(scoreLogComplete, "log complete (last_complete == last_update)")
+		} else {
+			add(scoreLogIncomplete, "last_complete %s behind last_update %s, objects missing locally", info.LastComplete, info.LastUpdate)
+		}
+
+		if c.backfilling || (info.LastBackfill != "" && info.LastBackfill != lastBackfillComplete) {
+			add(scoreBackfilling, "backfill incomplete (last_backfill %q)", info.LastBackfill)
+		}
+
+		if info.StartedEpoch() == newestStarted {
+			add(scoreNewestStarted, "newest last_epoch_started %d", newestStarted)
+		} else {
+			add(0, "last_epoch_started %d behind %d", info.StartedEpoch(), newestStarted)
+		}
+
+		if info.History.SameIntervalSince == newestInterval {
+			add(scoreNewestInterval, "newest same_interval_since %d", newestInterval)
+		}
+
+		if missing := info.Stats.StatSum.NumObjectsMissing; missing > 0 {
+			add(max(missing*scoreMissingPerObject, scoreMissingMax), "%d objects missing", missing)
+		}
+
+		if info.Stats.StatSum.NumObjects == mostObjects {
+			add(scoreMostObjects, "most objects (%d)", mostObjects)
+		}
+
+		if info.LogTail == oldestTail {
+			add(scoreLongestLog, "longest log (log_tail %s)", oldestTail)
+		}
+
+		ranking = append(ranking, r)
+	}
+
+	sort.SliceStable(ranking, func(i, j int) bool {
+		if ranking[i].disqualified != ranking[j].disqualified {
+			return ranking[j].disqualified
+		}
+		return ranking[i].Score > ranking[j].Score
+	})
+	return ranking
+}
+
+// divergenceWarnings points out pairs of candidates where no single copy wins
+// on every axis, which is exactly when the ranking needs a human to check it.
+func divergenceWarnings(candidates []candidate) []string {
+	var warnings []string
+	for i, a := range candidates {
+		for _, b := range candidates[i+1:] {
+			switch cmp := a.info.LastUpdate.Compare(b.info.LastUpdate); {
+			case cmp == 0:
+				if a.info.LastComplete != b.info.LastComplete {
+					warnings = append(warnings, fmt.Sprintf("%s and %s share last_update %s but differ in last_complete (%s vs %s)",
+						a.name, b.name, a.info.LastUpdate, a.info.LastComplete, b.info.LastComplete))
+				}
+				if a.info.Stats.StatSum.NumObjects != b.info.Stats.StatSum.NumObjects {
+					warnings = append(warnings, fmt.Sprintf("%s and %s share last_update %s but hold %d vs %d objects",
+						a.name, b.name, a.info.LastUpdate, a.info.Stats.StatSum.NumObjects, b.info.Stats.StatSum.NumObjects))
+				}
+			default:
+				newer, older := a, b
+				if cmp < 0 {
+					newer, older = b, a
+				}
+				if newer.info.LastComplete.Less(older.info.LastComplete) {
+					warnings = append(warnings, fmt.Sprintf("%s has newer last_update (%s) but %s has newer last_complete (%s): the newer log is incomplete",
+						newer.name, newer.info.LastUpdate, older.name, older.info.LastComplete))
+				}
+				if newer.info.StartedEpoch() > 0 && newer.info.StartedEpoch() < older.info.StartedEpoch() {
+					warnings = append(warnings, fmt.Sprintf("%s has newer last_update (%s) but %s started in a later epoch (%d): possible divergent log",
+						newer.name, newer.info.LastUpdate, older.name, older.info.StartedEpoch()))
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+func recoveryStateWarnings(query *ceph.PGQuery) []string {
+	var warnings []string
+	for _, state := range query.RecoveryState {
+		if state.Blocked != "" {
+			warnings = append(warnings, fmt.Sprintf("cluster: %s: %s", state.Name, state.Blocked))
+		}
+		for _, blocker := range state.PeeringBlockedBy {
+			warnings = append(warnings, fmt.Sprintf("cluster: peering blocked by osd.%d: %s", blocker.OSD, blocker.Comment))
+		}
+		if len(state.DownOSDsWeWouldProbe) > 0 {
+			warnings = append(warnings, fmt.Sprintf("cluster: would probe down OSDs %v", state.DownOSDsWeWouldProbe))
+		}
+		for _, unfound := range state.MightHaveUnfound {
+			if unfound.Status != "already probed" {
+				warnings = append(warnings, fmt.Sprintf("cluster: osd.%s might have unfound objects (%s)", unfound.OSD, unfound.Status))
+			}
+		}
+	}
+	if unfound := query.Info.Stats.StatSum.NumObjectsUnfound; unfound > 0 {
+		warnings = append(warnings, fmt.Sprintf("cluster: %d unfound objects", unfound))
+	}
+	return warnings
+}
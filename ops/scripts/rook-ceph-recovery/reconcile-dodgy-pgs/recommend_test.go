@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"main/internal/ceph"
+)
+
+// osdCopy returns an on-disk source parsed from --op info JSON.
+func osdCopy(t *testing.T, osd int, info string) source {
+	t.Helper()
+	src := source{name: fmt.Sprintf("osd%d", osd), osd: osd, raw: info}
+	if err := json.Unmarshal([]byte(info), &src.info); err != nil {
+		t.Fatalf("osd%d info: %v", osd, err)
+	}
+	return src
+}
+
+func TestRecommendRanksDisqualifiedLast(t *testing.T) {
+	// Newest and complete on every axis, but marked incomplete
+	best := `{"pgid":"1.1a","last_update":"120'50","last_complete":"120'50","log_tail":"90'1",
+		"last_backfill":"MAX","history":{"last_epoch_started":130,"same_interval_since":130},
+		"stats":{"stat_sum":{"num_objects":20}}`
+	// Behind, with an incomplete log, still backfilling and missing objects:
+	// as low as a usable copy scores
+	poor := `{"pgid":"1.1a","last_update":"110'30","last_complete":"110'10","log_tail":"100'1",
+		"last_backfill":"1:abc","history":{"last_epoch_started":100,"same_interval_since":100},
+		"stats":{"stat_sum":{"num_objects":5,"num_objects_missing":50}}}`
+
+	for _, state := range []string{"incomplete", "dne", "empty"} {
+		t.Run(state, func(t *testing.T) {
+			sources := []source{
+				osdCopy(t, 1, best+`,"`+state+`":1}`),
+				osdCopy(t, 2, poor),
+			}
+			rec := recommend("1.1a", nil, sources)
+
+			if len(rec.Ranking) != 2 {
+				t.Fatalf("ranking = %+v", rec.Ranking)
+			}
+			first, last := rec.Ranking[0], rec.Ranking[1]
+			if first.Name != "osd2" || last.Name != "osd1" {
+				t.Fatalf("ranked %s (%d) above %s (%d), want the %s copy last",
+					first.Name, first.Score, last.Name, last.Score, state)
+			}
+			if first.Score > last.Score {
+				t.Errorf("test copies no longer overlap in score (%d vs %d)", first.Score, last.Score)
+			}
+			for _, w := range rec.Warnings {
+				if strings.Contains(w, "tied") {
+					t.Errorf("warned %q about a disqualified copy", w)
+				}
+			}
+		})
+	}
+}
+
+func TestRecommendFallsBackToPeerInfo(t *testing.T) {
+	query := &ceph.PGQuery{Acting: []int{3}}
+	if err := json.Unmarshal([]byte(`{"peer_info":[{"peer":"4(1)","pgid":"1.1a","last_update":"120'45","last_complete":"120'45"}]}`), query); err != nil {
+		t.Fatal(err)
+	}
+	sources := []source{
+		{name: "cluster", osd: -1},
+		{name: "osd4", osd: 4, err: fmt.Errorf("no maintenance pod")},
+		{name: "osd5", osd: 5, err: fmt.Errorf("no maintenance pod")},
+	}
+
+	rec := recommend("1.1a", query, sources)
+	if len(rec.Ranking) != 1 || rec.Ranking[0].Name != "osd4" || rec.Ranking[0].Origin != peerInfoOrigin {
+		t.Errorf("ranking = %+v, want osd4 from the primary's peer_info", rec.Ranking)
+	}
+	if len(rec.Excluded) != 1 || !strings.HasPrefix(rec.Excluded[0], "osd5:") {
+		t.Errorf("excluded = %q, want osd5", rec.Excluded)
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"main/internal/ceph"
+	"main/internal/executor"
+	"main/internal/rook"
+)
+
+func main() {
+	pgs := flag.String("pgs", "", "Comma-separated PG IDs")
+	osds := flag.String("osds", "", "Comma-separated OSD IDs")
+	replay := flag.String("replay", "", "Directory of saved pg_<id>_cluster.json / pg_<id>_osd_<n>.json files to replay instead of querying the cluster")
+	output := flag.String("output", "table", "Output format: "+strings.Join(outputFormats, "|"))
+	namespace := flag.String("namespace", "rook-ceph", "Rook namespace")
+	color := flag.String("color", "auto", "Colour the table output: auto|always|never")
+	objects := flag.Bool("objects", false, "Also compare the object listing of each PG across OSDs")
+	objectVersions := flag.Bool("object-versions", false, "With -objects, dump each divergent object to report its version (slow)")
+	concurrency := flag.Int("concurrency", 4, "Maximum number of kubectl commands running at once")
+	timeout := flag.Duration("timeout", 5*time.Minute, "Deadline for each kubectl command (0 for none)")
+	discover := flag.Bool("discover", false, "Find dodgy PGs (unless -pgs is given) and the OSDs to query for each (unless -osds is given) from the cluster")
+	flag.Parse()
+
+	if !*discover && (*pgs == "" || *osds == "") {
+		fmt.Println("Usage: go run ./reconcile-dodgy-pgs -pgs=1.1a,1.1b -osds=2,3,4 [-replay=<dir>] [-output=table|json|yaml|markdown|csv] [-objects] [-concurrency=4] [-timeout=5m]")
+		fmt.Println("       go run ./reconcile-dodgy-pgs -discover [-pgs=...] [-osds=...] [...]")
+		return
+	}
+
+	out, err := newReportWriter(*output, os.Stdout, useColor(*color, os.Stdout))
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	// Keep stdout clean for machine-readable formats
+	progress := os.Stdout
+	if *output != "table" {
+		progress = os.Stderr
+	}
+
+	var pgIDs []string
+	if *pgs != "" {
+		pgIDs = strings.Split(*pgs, ",")
+	}
+	osdIDs := parseOSDs(*osds)
+
+	ctx := context.Background()
+	var ex executor.Executor = executor.Command{}
+	var fixture *executor.Fixture
+	if *replay != "" {
+		fixture = executor.NewFixture(*replay)
+		replayDiscovery(fixture)
+		ex = fixture
+	}
+
+	c := &collector{
+		ex:             executor.NewLimited(ex, *concurrency, *timeout),
+		namespace:      *namespace,
+		save:           *replay == "",
+		objects:        *objects,
+		objectVersions: *objectVersions,
+		pgOSDs:         make(map[string][]int),
+		queries:        make(map[string]string),
+		osdPods:        make(map[int]string),
+		osdErrs:        make(map[int]error),
+	}
+
+	failed := false
+	if *discover {
+		d := &discoverer{ex: c.ex, save: c.save}
+
+		var candidates map[string]*Candidate
+		var problems []string
+		if len(pgIDs) == 0 {
+			candidates, problems = d.discoverPGs(ctx)
+			for pgid := range candidates {
+				pgIDs = append(pgIDs, pgid)
+			}
+			sort.Strings(pgIDs)
+		} else {
+			candidates = make(map[string]*Candidate)
+			for _, pgid := range pgIDs {
+				candidates[pgid] = &Candidate{PGID: pgid, Reasons: []string{"requested"}}
+			}
+		}
+
+		if fixture != nil {
+			replayPGs(fixture, pgIDs)
+		}
+		problems = append(problems, d.resolveOSDs(ctx, candidates)...)
+
+		for _, problem := range problems {
+			failed = true
+			_, _ = fmt.Fprintf(os.Stderr, "Discovery: %s\n", problem)
+		}
+
+		_, _ = fmt.Fprintf(progress, "Discovered %d PGs\n", len(pgIDs))
+		for _, pgid := range pgIDs {
+			cand := candidates[pgid]
+			c.queries[pgid] = cand.query
+			c.pgOSDs[pgid] = cand.OSDs()
+			_, _ = fmt.Fprintf(progress, "  %s (%s): up [%s] acting [%s] historical [%s]\n", pgid, strings.Join(cand.Reasons, ", "),
+				formatOSDs(cand.Up), formatOSDs(cand.Acting), formatOSDs(cand.Historical))
+		}
+	}
+
+	// An explicit -osds list is queried for every PG, discovered or not
+	if len(osdIDs) > 0 {
+		for _, pgid := range pgIDs {
+			c.pgOSDs[pgid] = osdIDs
+		}
+	}
+
+	if len(pgIDs) == 0 {
+		_, _ = fmt.Fprintln(progress, "No dodgy PGs found")
+	}
+
+	var allOSDs []int
+	for _, ids := range c.pgOSDs {
+		allOSDs = mergeOSDs(allOSDs, ids)
+	}
+	if fixture != nil {
+		if !*discover {
+			replayPGs(fixture, pgIDs)
+		}
+		replayOSDs(fixture, c.namespace, c.pgOSDs)
+	}
+
+	// Find maintenance pods for OSDs
+	for _, id := range allOSDs {
+		pod, err := rook.FindMaintenancePod(ctx, c.ex, c.namespace, id)
+		if err != nil {
+			_, _ = fmt.Fprintf(progress, "Failed to find maintenance pod for OSD %d: %v\n", id, err)
+			c.osdErrs[id] = err
+			continue
+		}
+		c.osdPods[id] = pod
+	}
+
+	// Query all PGs concurrently (the executor bounds how many commands
+	// actually run), but report them in the order they were asked for.
+	results := make([]chan pgResult, len(pgIDs))
+	var done atomic.Int32
+	for i, pgid := range pgIDs {
+		results[i] = make(chan pgResult, 1)
+		go func(pgid string, result chan<- pgResult) {
+			start := time.Now()
+			r := c.collect(ctx, pgid)
+			_, _ = fmt.Fprintf(os.Stderr, "[%d/%d] PG %s queried in %s\n", done.Add(1), len(pgIDs), pgid, time.Since(start).Round(time.Millisecond))
+			result <- r
+		}(pgid, results[i])
+	}
+
+	for i, pgid := range pgIDs {
+		r := <-results[i]
+		_, _ = fmt.Fprintf(progress, "\nProcessing PG %s\n", pgid)
+		for _, problem := range r.problems {
+			failed = true
+			_, _ = fmt.Fprintf(os.Stderr, "PG %s: %s\n", pgid, problem)
+		}
+
+		if err := out.WritePG(r.comparison); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error writing PG %s: %v\n", pgid, err)
+			os.Exit(2)
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+		os.Exit(2)
+	}
+
+	// Exit 1 only for unreadable sources, which the report marks UNAVAILABLE;
+	// failures to produce the report at all exit 2.
+	if failed {
+		_, _ = fmt.Fprintln(os.Stderr, "\nOne or more sources could not be read; results above are incomplete")
+		os.Exit(1)
+	}
+}
+
+// collector gathers everything reported about a PG from the cluster and the
+// OSD maintenance pods.
+type collector struct {
+	ex             executor.Executor
+	namespace      string
+	save           bool
+	objects        bool
+	objectVersions bool
+	pgOSDs         map[string][]int  // OSDs to query for each PG
+	queries        map[string]string // pg query output already read during discovery
+	osdPods        map[int]string
+	osdErrs        map[int]error // why an OSD has no usable maintenance pod
+}
+
+// pgResult is the comparison for one PG plus every source that could not be
+// read, so they can be reported in PG order once the queries are done.
+type pgResult struct {
+	comparison Comparison
+	problems   []string
+}
+
+func (c *collector) collect(ctx context.Context, pgid string) pgResult {
+	var query *ceph.PGQuery
+	osdIDs := c.pgOSDs[pgid]
+	sources := make([]source, len(osdIDs)+1)
+
+	var wg sync.WaitGroup
+	wg.Add(len(sources))
+	go func() {
+		defer wg.Done()
+		sources[0], query = c.clusterSource(ctx, pgid)
+	}()
+	for i, id := range osdIDs {
+		go func(i, id int) {
+			defer wg.Done()
+			sources[i+1] = c.osdSource(ctx, pgid, id)
+		}(i, id)
+	}
+	wg.Wait()
+
+	var r pgResult
+	for _, src := range sources {
+		if src.err != nil {
+			r.problems = append(r.problems, fmt.Sprintf("%s UNAVAILABLE: %v", src.name, src.err))
+		}
+	}
+
+	r.comparison = compare(pgid, query, sources)
+	if c.objects {
+		oc, problems := c.compareObjects(ctx, pgid)
+		r.comparison.Objects = &oc
+		r.problems = append(r.problems, problems...)
+	}
+	return r
+}
+
+// clusterSource queries the cluster using the kubectl rook-ceph plugin.
+func (c *collector) clusterSource(ctx context.Context, pgid string) (source, *ceph.PGQuery) {
+	src := source{name: "cluster", osd: -1}
+	clusterJSON := c.queries[pgid]
+	if clusterJSON == "" {
+		var err error
+		clusterJSON, err = queryCluster(ctx, c.ex, pgid)
+		if err != nil {
+			src.err = err
+			return src, nil
+		}
+		if c.save {
+			saveJSON(fmt.Sprintf("pg_%s_cluster.json", pgid), clusterJSON)
+		}
+	}
+
+	src.raw = clusterJSON
+
+	var q ceph.PGQuery
+	if err := json.Unmarshal([]byte(clusterJSON), &q); err != nil {
+		src.err = fmt.Errorf("unmarshaling cluster JSON: %v", err)
+		return src, nil
+	}
+	src.info = q.Info
+	return src, &q
+}
+
+func (c *collector) osdSource(ctx context.Context, pgid string, id int) source {
+	src := source{name: fmt.Sprintf("osd%d", id), osd: id}
+	pod, ok := c.osdPods[id]
+	if !ok {
+		src.err = c.osdErrs[id]
+		return src
+	}
+
+	osdJSON, err := queryOSD(ctx, c.ex, c.namespace, pod, id, pgid)
+	if err != nil {
+		src.err = err
+		return src
+	}
+
+	src.raw = osdJSON
+	if c.save {
+		saveJSON(fmt.Sprintf("pg_%s_osd_%d.json", pgid, id), osdJSON)
+	}
+	if err := json.Unmarshal([]byte(osdJSON), &src.info); err != nil {
+		src.err = fmt.Errorf("unmarshaling OSD %d JSON: %v", id, err)
+	}
+	return src
+}
+
+func (c *collector) compareObjects(ctx context.Context, pgid string) (ObjectComparison, []string) {
+	osdIDs := c.pgOSDs[pgid]
+	listings := make([]objectListing, len(osdIDs))
+
+	var wg sync.WaitGroup
+	for i, id := range osdIDs {
+		wg.Add(1)
+		go func(l *objectListing, id int) {
+			defer wg.Done()
+			l.name, l.osd, l.pod = fmt.Sprintf("osd%d", id), id, c.osdPods[id]
+			if l.pod == "" {
+				l.err = c.osdErrs[id]
+				return
+			}
+
+			var raw string
+			raw, l.entries, l.err = listObjects(ctx, c.ex, c.namespace, l.pod, id, pgid)
+			if l.err == nil && c.save {
+				saveJSON(fmt.Sprintf("pg_%s_osd_%d_list.json", pgid, id), raw)
+			}
+		}(&listings[i], id)
+	}
+	wg.Wait()
+
+	var problems []string
+	for _, l := range listings {
+		if l.err != nil {
+			problems = append(problems, fmt.Sprintf("%s object list UNAVAILABLE: %v", l.name, l.err))
+		}
+	}
+
+	oc := compareObjects(listings)
+	if c.objectVersions {
+		// Version lookups are best effort and do not make the run fail
+		for _, err := range addObjectVersions(ctx, c.ex, c.namespace, pgid, &oc, listings) {
+			_, _ = fmt.Fprintf(os.Stderr, "PG %s: object version unavailable: %v\n", pgid, err)
+		}
+	}
+	return oc, problems
+}
+
+// source is one column of the comparison: the cluster's or a single OSD's
+// view of a PG. A source with a non-nil err could not be read and must not be
+// mistaken for one that is empty.
+type source struct {
+	name string
+	osd  int // -1 for the cluster
+	info ceph.PGInfo
+	raw  string
+	err  error
+}
+
+// useColor resolves -color; auto colours only when writing to a terminal.
+func useColor(mode string, f *os.File) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func parseOSDs(osdStr string) []int {
+	var ids []int
+	for _, s := range strings.Split(osdStr, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// The commands below are the only way the tool talks to the cluster; they are
+// kept in one place so replayDiscovery, replayPGs and replayOSDs can register
+// the exact same invocations.
+
+func clusterQueryCommand(pgid string) []string {
+	return []string{"kubectl", "rook-ceph", "ceph", "pg", pgid, "query"}
+}
+
+func run(ctx context.Context, ex executor.Executor, cmd []string) ([]byte, error) {
+	return ex.Run(ctx, cmd[0], cmd[1:]...)
+}
+
+// The replay functions map the commands the tool would run onto the files it
+// saves during a live run, so a capture directory can be replayed offline.
+// They are registered in phases because discovery decides which PGs and OSDs
+// are queried.
+
+func replayDiscovery(fixture *executor.Fixture) {
+	cmd := stuckCommand()
+	fixture.File("discover_dump_stuck.json", cmd[0], cmd[1:]...)
+	for _, state := range discoveredStates {
+		cmd = pgLsCommand(state)
+		fixture.File(fmt.Sprintf("discover_pg_ls_%s.json", state), cmd[0], cmd[1:]...)
+	}
+	cmd = healthDetailCommand()
+	fixture.File("discover_health_detail.json", cmd[0], cmd[1:]...)
+}
+
+func replayPGs(fixture *executor.Fixture, pgIDs []string) {
+	for _, pgid := range pgIDs {
+		query := clusterQueryCommand(pgid)
+		fixture.File(fmt.Sprintf("pg_%s_cluster.json", pgid), query[0], query[1:]...)
+	}
+}
+
+func replayOSDs(fixture *executor.Fixture, ns string, pgOSDs map[string][]int) {
+	pods := make(map[int]string)
+	for pgid, osdIDs := range pgOSDs {
+		for _, id := range osdIDs {
+			if _, ok := pods[id]; !ok {
+				pods[id] = rook.ReplayMaintenance(fixture, ns, id)
+			}
+
+			info := ceph.InfoCommand(ns, pods[id], id, pgid)
+			fixture.File(fmt.Sprintf("pg_%s_osd_%d.json", pgid, id), info[0], info[1:]...)
+
+			list := ceph.ListCommand(ns, pods[id], id, pgid)
+			fixture.File(fmt.Sprintf("pg_%s_osd_%d_list.json", pgid, id), list[0], list[1:]...)
+		}
+	}
+}
+
+func queryCluster(ctx context.Context, ex executor.Executor, pgid string) (string, error) {
+	out, err := run(ctx, ex, clusterQueryCommand(pgid))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func queryOSD(ctx context.Context, ex executor.Executor, ns, pod string, osd int, pgid string) (string, error) {
+	out, err := run(ctx, ex, ceph.InfoCommand(ns, pod, osd, pgid))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func saveJSON(file string, data string) {
+	err := os.WriteFile(file, []byte(data), 0644)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Failed to save %s: %v\n", file, err)
+	}
+}
+
+// findMostRecent returns the name of the readable source with the newest
+// last_update, or "" if no source could be read.
+func findMostRecent(sources []source) string {
+	mostRecent := ""
+	var newest ceph.Eversion
+	for _, src := range sources {
+		if src.err != nil {
+			continue
+		}
+		if mostRecent == "" || newest.Less(src.info.LastUpdate) {
+			mostRecent = src.name
+			newest = src.info.LastUpdate
+		}
+	}
+	return mostRecent
+}
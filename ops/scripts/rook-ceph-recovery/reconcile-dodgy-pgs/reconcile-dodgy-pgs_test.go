@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"main/internal/executor"
+	"main/internal/rook"
+)
+
+// replayCollector returns a collector that replays the captures saved in
+// testdata/<capture> for pgid's OSDs, the way -replay does.
+func replayCollector(t *testing.T, capture, pgid string, osds []int) *collector {
+	t.Helper()
+
+	fixture := executor.NewFixture(filepath.Join("testdata", capture))
+	c := &collector{
+		ex:        fixture,
+		namespace: "rook-ceph",
+		pgOSDs:    map[string][]int{pgid: osds},
+		queries:   make(map[string]string),
+		osdPods:   make(map[int]string),
+		osdErrs:   make(map[int]error),
+	}
+	replayPGs(fixture, []string{pgid})
+	replayOSDs(fixture, c.namespace, c.pgOSDs)
+
+	for _, id := range osds {
+		pod, err := rook.FindMaintenancePod(context.Background(), fixture, c.namespace, id)
+		if err != nil {
+			t.Fatalf("FindMaintenancePod(%d): %v", id, err)
+		}
+		c.osdPods[id] = pod
+	}
+	return c
+}
+
+func TestCollectReplay(t *testing.T) {
+	tests := []struct {
+		capture     string
+		osds        []int
+		unavailable []string
+		mostRecent  string
+		// outliers are the sources off the majority last_update
+		outliers []string
+		ranking  []Ranked
+		warnings []string
+	}{
+		{
+			// osd2 has the newest last_update but an incomplete log; the
+			// primary osd3 is complete one version behind.
+			capture:    "diverged",
+			osds:       []int{2, 3},
+			mostRecent: "osd2",
+			outliers:   []string{"osd2"},
+			ranking: []Ranked{
+				{Name: "osd2", Origin: onDiskOrigin, Score: 96},
+				{Name: "osd3", Origin: onDiskOrigin, Score: 50},
+			},
+			warnings: []string{
+				"cluster: peering blocked by osd.5: starting or marking this osd lost may let us proceed",
+				"osd2: cluster reports last_update 120'44 but on-disk info has 120'46",
+				"osd2 has newer last_update (120'46) but osd3 has newer last_complete (120'45): the newer log is incomplete",
+			},
+		},
+		{
+			// osd4's on-disk info was not captured, so it is ranked from the
+			// primary's peer_info, where it is still backfilling.
+			capture:     "unreadable-osd",
+			osds:        []int{3, 4},
+			unavailable: []string{"osd4"},
+			mostRecent:  "cluster",
+			ranking: []Ranked{
+				{Name: "osd3", Origin: onDiskOrigin, Score: 175},
+				{Name: "osd4", Origin: peerInfoOrigin, Score: -15},
+			},
+			warnings: []string{
+				"cluster: would probe down OSDs [5]",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.capture, func(t *testing.T) {
+			c := replayCollector(t, tt.capture, "1.1a", tt.osds)
+			r := c.collect(context.Background(), "1.1a")
+			cmp := r.comparison
+
+			var unavailable []string
+			for _, s := range cmp.Sources {
+				if !s.Available {
+					unavailable = append(unavailable, s.Name)
+				}
+			}
+			if !reflect.DeepEqual(unavailable, tt.unavailable) {
+				t.Errorf("unavailable sources = %v, want %v", unavailable, tt.unavailable)
+			}
+			if len(r.problems) != len(tt.unavailable) {
+				t.Errorf("problems = %q, want one per unavailable source", r.problems)
+			}
+
+			if cmp.MostRecent != tt.mostRecent {
+				t.Errorf("MostRecent = %q, want %q", cmp.MostRecent, tt.mostRecent)
+			}
+
+			lastUpdate := cmp.Fields[0]
+			if lastUpdate.Field != "last_update" || !lastUpdate.Majority {
+				t.Fatalf("first field = %+v, want last_update with a majority", lastUpdate)
+			}
+			var outliers []string
+			for _, v := range lastUpdate.Values {
+				if v.Outlier {
+					outliers = append(outliers, v.Source)
+				}
+			}
+			if !reflect.DeepEqual(outliers, tt.outliers) {
+				t.Errorf("last_update outliers = %v, want %v", outliers, tt.outliers)
+			}
+
+			rec := cmp.Recommendation
+			if len(rec.Ranking) != len(tt.ranking) {
+				t.Fatalf("ranking = %+v, want %d entries", rec.Ranking, len(tt.ranking))
+			}
+			for i, want := range tt.ranking {
+				got := rec.Ranking[i]
+				if got.Name != want.Name || got.Origin != want.Origin || got.Score != want.Score {
+					t.Errorf("ranking[%d] = %s/%s/%d, want %s/%s/%d (reasons %q)",
+						i, got.Name, got.Origin, got.Score, want.Name, want.Origin, want.Score, got.Reasons)
+				}
+			}
+
+			for _, want := range tt.warnings {
+				if !containsString(rec.Warnings, want) {
+					t.Errorf("warnings = %q, missing %q", rec.Warnings, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayReportFormats(t *testing.T) {
+	c := replayCollector(t, "diverged", "1.1a", []int{2, 3})
+	cmp := c.collect(context.Background(), "1.1a").comparison
+
+	for _, format := range outputFormats {
+		t.Run(format, func(t *testing.T) {
+			var buf strings.Builder
+			w, err := newReportWriter(format, &buf, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WritePG(cmp); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{"1.1a", "osd2", "120'46"} {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("%s output does not mention %q:\n%s", format, want, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}